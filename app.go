@@ -14,12 +14,18 @@
 package galago
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"os"
+	"runtime/debug"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -42,6 +48,10 @@ const (
 // initiating requests.
 type ClientIDFactory func(*http.Request) string
 
+// DefaultMaxBodyBytes is the maximum size of a request body in bytes
+// when no maximum has been configured on the App or Route.
+const DefaultMaxBodyBytes = 10 << 20
+
 // App is the default object for a restful application.
 type App struct {
 	// The mode in which to run the web server.
@@ -58,10 +68,28 @@ type App struct {
 	Middleware []Middleware
 	// The default serializer to use for all requests and responses.
 	Serializer *Serializer
-	// The global rate limit for all requests.
+	// The global rate limit for all requests. This is ignored if
+	// GlobalLimitStore is set.
 	GlobalLimit *rate.Limiter
-	// The per-client rate limit.
+	// The store used for the global rate limit for all requests. All
+	// requests are counted against the same empty key.
+	GlobalLimitStore RateLimitStore
+	// The per-client rate limit. This is ignored if ClientLimitStore
+	// is set.
 	ClientLimit *rate.Limiter
+	// The store used for the per-client rate limit. Requests are
+	// counted against the key generated by the ClientIDFactory.
+	ClientLimitStore RateLimitStore
+	// The amount of time a client can remain idle before the state
+	// for its rate limits is discarded. This applies to ClientLimit
+	// and to the Limit of each Route. Defaults to
+	// DefaultClientLimitTTL.
+	ClientLimitTTL time.Duration
+	// The maximum number of clients for which rate limit state is
+	// kept, for ClientLimit and for the Limit of each Route. Once
+	// reached, the least recently seen client is discarded. Defaults
+	// to DefaultClientLimitMaxEntries.
+	ClientLimitMaxEntries int
 	// Used to generate a unique client identifier.
 	ClientIDFactory ClientIDFactory
 	// The TLS address if running in ModeHTTPS.
@@ -70,9 +98,72 @@ type App struct {
 	TLSCertFile string
 	// The TLS Key File if running in ModeHTTPS.
 	TLSKeyFile string
+	// A template for the http.Server used when running in ModeHTTP.
+	// Use this to configure timeouts, header limits and the error
+	// log. The Addr and Handler of the template are ignored.
+	HTTPServer *http.Server
+	// A template for the http.Server used when running in ModeHTTPS.
+	// Use this to configure timeouts, header limits, the error log
+	// and the tls.Config used for cipher suites and the minimum TLS
+	// version. The Addr and Handler of the template are ignored.
+	HTTPSServer *http.Server
 	// Whether or not this App should log ACCESS messages.
-	LogAccess    bool
-	clientLimits map[string]*rate.Limiter
+	LogAccess bool
+	// PanicHandler is called when a panic is recovered while
+	// handling a request, along with the value that was recovered
+	// and the stack trace of the panic. Use this to report panics to
+	// an external service. The client receives a 500 Internal Server
+	// Error response regardless.
+	PanicHandler func(r *http.Request, recovered interface{}, stack []byte)
+	// The maximum size of a request body in bytes. Requests with a
+	// larger body receive a 413 Payload Too Large response. This can
+	// be overridden for each Route. Use a negative value to remove the
+	// limit. Defaults to DefaultMaxBodyBytes.
+	MaxBodyBytes int64
+	// The maximum number of bytes of a multipart form that are held
+	// in memory. Any files that do not fit are stored in temporary
+	// files until the request has been handled. Defaults to
+	// DefaultMaxMultipartMemory.
+	MaxMultipartMemory int64
+	// The interval at which heartbeats are sent on the EventStreams
+	// created with request.SSE(fn). Use a negative value to disable
+	// heartbeats. Defaults to DefaultSSEHeartbeat.
+	SSEHeartbeat time.Duration
+	// The interval at which pings are sent on WebSockets to keep them
+	// alive. A WebSocket that receives nothing from the client before
+	// the next ping is due is closed. Use a negative value to disable
+	// pings. Defaults to DefaultWebSocketPingInterval.
+	WebSocketPingInterval time.Duration
+	// The maximum size of a message received on a WebSocket in bytes.
+	// A larger message closes the WebSocket with CloseMessageTooBig.
+	// Defaults to DefaultWebSocketMaxMessageBytes.
+	WebSocketMaxMessageBytes int64
+	// CheckWebSocketOrigin reports whether a WebSocket can be opened
+	// by the specified request. Requests that are not allowed receive
+	// a 403 Forbidden response. Defaults to allowing requests without
+	// an Origin header, and requests with an Origin matching their
+	// Host.
+	CheckWebSocketOrigin func(r *http.Request) bool
+	// ErrorMapper converts the errors returned from Routes created
+	// with Handle into Responses. Defaults to DefaultErrorMapper.
+	ErrorMapper ErrorMapper
+	// The amount of time to wait for in-flight requests to complete
+	// when the context passed to ListenContext is done, or when
+	// Listen receives SIGINT or SIGTERM. Defaults to
+	// DefaultDrainTimeout.
+	DrainTimeout  time.Duration
+	startHooks    []func() error
+	shutdownHooks []func(context.Context) error
+	validators    map[string]Validator
+	serializers   []mediaSerializer
+	webSockets    map[*WebSocket]struct{}
+	running       *lifecycle
+	mu            sync.Mutex
+	globalLimits  RateLimitStore
+	clientLimits  RateLimitStore
+	router        *router
+	routerErr     error
+	routerOnce    sync.Once
 }
 
 // NewAppFromCLI will generate a new App using the parameters passed
@@ -89,6 +180,25 @@ func NewAppFromCLI() *App {
 		"https-cert", "", "the certificate file to use for HTTPS")
 	tlsKeyFilePtr := flag.String(
 		"https-key", "", "the key file to use for HTTPS")
+	tlsMinVersionPtr := flag.String(
+		"https-min-version", "",
+		"the minimum TLS version to accept for HTTPS (1.0, 1.1, 1.2, 1.3)")
+	readTimeoutPtr := flag.Duration(
+		"read-timeout", 0,
+		"the maximum duration for reading an entire request")
+	readHeaderTimeoutPtr := flag.Duration(
+		"read-header-timeout", 0,
+		"the maximum duration for reading the headers of a request")
+	writeTimeoutPtr := flag.Duration(
+		"write-timeout", 0,
+		"the maximum duration before timing out writes of a response")
+	idleTimeoutPtr := flag.Duration(
+		"idle-timeout", 0,
+		"the maximum duration to wait for the next request on a "+
+			"keep-alive connection")
+	maxHeaderBytesPtr := flag.Int(
+		"max-header-bytes", 0,
+		"the maximum number of bytes read from request headers")
 
 	flag.Parse()
 
@@ -103,12 +213,41 @@ func NewAppFromCLI() *App {
 		os.Exit(1)
 	}
 
+	newServer := func() *http.Server {
+		return &http.Server{
+			ReadTimeout:       *readTimeoutPtr,
+			ReadHeaderTimeout: *readHeaderTimeoutPtr,
+			WriteTimeout:      *writeTimeoutPtr,
+			IdleTimeout:       *idleTimeoutPtr,
+			MaxHeaderBytes:    *maxHeaderBytesPtr,
+		}
+	}
+
+	httpsServer := newServer()
+	if *tlsMinVersionPtr != "" {
+		versions := map[string]uint16{
+			"1.0": tls.VersionTLS10,
+			"1.1": tls.VersionTLS11,
+			"1.2": tls.VersionTLS12,
+			"1.3": tls.VersionTLS13,
+		}
+		version, exists := versions[*tlsMinVersionPtr]
+		if !exists {
+			fmt.Fprint(os.Stderr,
+				"error: invalid -https-min-version, see -h for help.\n")
+			os.Exit(1)
+		}
+		httpsServer.TLSConfig = &tls.Config{MinVersion: version}
+	}
+
 	return &App{
 		Mode:        mode,
 		Address:     *addressPtr,
 		TLSAddress:  *tlsAddressPtr,
 		TLSCertFile: *tlsCertFilePtr,
 		TLSKeyFile:  *tlsKeyFilePtr,
+		HTTPServer:  newServer(),
+		HTTPSServer: httpsServer,
 	}
 }
 
@@ -123,175 +262,443 @@ func (app *App) getRoutes() RouteCollection {
 	return getAllRoutes(app.Controllers...)
 }
 
-// Listen will start listening for HTTP and HTTPS requests sent to the
-// application and process them respectively.
-func (app *App) Listen() {
-	if len(app.getRoutes()) < 1 {
-		if logger != nil {
-			logger.Print("warning : no routes defined")
+// compile builds the router for the App from the Routes in its
+// Controllers. The router is only built once, so any Controllers or
+// Routes added after the first request has been served, or after
+// Listen has been called, will be ignored. If any Route has an
+// invalid path, an error is returned.
+func (app *App) compile() (*router, error) {
+	app.routerOnce.Do(func() {
+		routes := app.getRoutes()
+		app.router, app.routerErr = newRouter(routes)
+
+		app.globalLimits = app.GlobalLimitStore
+		if app.globalLimits == nil && app.GlobalLimit != nil {
+			app.globalLimits = limiterStore{limiter: app.GlobalLimit}
 		}
-	}
 
-	if logger != nil {
-		for _, route := range app.getRoutes() {
-			logger.Printf(
-				"initialize : loaded route %v %p\n",
-				route.Path, route.Handler)
+		app.clientLimits = app.ClientLimitStore
+		if app.clientLimits == nil && app.ClientLimit != nil {
+			app.clientLimits = app.newClientLimitStore(app.ClientLimit)
 		}
-	}
-
-	var wg sync.WaitGroup
 
-	if ModeHTTP&app.Mode == ModeHTTP {
-		wg.Add(1)
-		go func() {
-			if logger != nil {
-				logger.Printf(
-					"initialize : http starting at %s\n", app.Address)
-				logger.Fatal(http.ListenAndServe(app.Address, app))
-			} else {
-				log.Fatal(http.ListenAndServe(app.Address, app))
+		for _, route := range routes {
+			route.limits = route.LimitStore
+			if route.limits == nil && route.Limit != nil {
+				route.limits = app.newClientLimitStore(route.Limit)
 			}
-			wg.Done()
-		}()
-	}
+		}
+	})
 
-	if ModeHTTPS&app.Mode == ModeHTTPS {
-		wg.Add(1)
-		go func() {
-			if logger != nil {
-				logger.Printf(
-					"initialize : https starting at %s\n",
-					app.TLSAddress)
-				logger.Fatal(http.ListenAndServeTLS(
-					app.TLSAddress, app.TLSCertFile,
-					app.TLSKeyFile, app))
-			} else {
-				log.Fatal(http.ListenAndServeTLS(
-					app.TLSAddress, app.TLSCertFile,
-					app.TLSKeyFile, app))
-			}
-		}()
-	}
+	return app.router, app.routerErr
+}
 
-	wg.Wait()
+// newClientLimitStore creates a TokenBucketStore giving each client a
+// copy of the specified reference Limiter.
+func (app *App) newClientLimitStore(reference *rate.Limiter) RateLimitStore {
+	return &TokenBucketStore{
+		Limit:      reference.Limit(),
+		Burst:      reference.Burst(),
+		TTL:        app.ClientLimitTTL,
+		MaxEntries: app.ClientLimitMaxEntries,
+	}
 }
 
 // ServeHTTP will handle the incoming request and respond to it.
 // First, process any configured rate limits from GlobalLimit and
 // ClientLimit. After rate limits have been processed, determine which
 // route to pass the request to. If no route can be determined,
-// respond with a default 404 Not Found. Otherwise, process any
+// respond with a default 404 Not Found, or a 405 Method Not Allowed if
+// the path is known but the method is not. Otherwise, process any
 // potential rate limits on the route and process the request.
+//
+// HEAD requests are answered by the GET Route for the path when no
+// HEAD Route has been registered, and OPTIONS requests are answered
+// automatically when no OPTIONS Route has been registered.
+//
+// Requests matching a Route are passed through the WrapHTTP functions
+// of the Middleware applied to the App and the Route before the
+// request body is read.
 func (app *App) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
 
-	app.rateLimit(w, r)
+	// Panics within Middleware and RouteHandlers are recovered by
+	// invoke, and panics within Terminate Middleware are recovered by
+	// terminate. This catches any that happen before the response is
+	// written, such as while serializing.
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			if recovered == http.ErrAbortHandler {
+				panic(recovered)
+			}
+			app.recoverPanic(r, recovered)
+			app.writeError(w, http.StatusInternalServerError,
+				"internal server error")
+			app.logAccess(r, nil, http.StatusInternalServerError, start)
+		}
+	}()
 
-	q := r.URL.RawQuery
-	if q != "" {
-		q = "?" + q
+	router, err := app.compile()
+	if err != nil {
+		if logger != nil {
+			logger.Printf("error : %v\n", err)
+		}
+		app.writeError(w, http.StatusInternalServerError,
+			"internal server error")
+		app.logAccess(r, nil, http.StatusInternalServerError, start)
+		return
 	}
+
+	if !app.rateLimit(w, r) {
+		app.logAccess(r, nil, http.StatusTooManyRequests, start)
+		return
+	}
+
 	path := r.URL.Path[1:]
 
-	for _, route := range app.getRoutes() {
-		if route.isURL(path) && route.Method == r.Method {
-			if route.Limit != nil && app.ClientIDFactory == nil {
-				logger.Printf(
-					"%s : %s\n", "warning",
-					"RouteLimit set but no ClientIDFactory")
+	route, fields, allowed := router.lookup(path, r.Method)
+	if route == nil {
+		status := http.StatusNotFound
+		if len(allowed) > 0 {
+			w.Header().Set("Allow", strings.Join(allowed, ", "))
+			if r.Method == http.MethodOptions {
+				status = http.StatusNoContent
+				w.WriteHeader(status)
 			} else {
-				if !route.allowed(app.ClientIDFactory, r) {
-					w.WriteHeader(http.StatusTooManyRequests)
-					return
-				}
+				status = http.StatusMethodNotAllowed
+				app.writeError(w, status, fmt.Sprintf(
+					"method %s not allowed", r.Method))
 			}
+		} else {
+			app.writeError(w, status, "not found")
+		}
 
-			serialized, contentType, request, response :=
-				app.process(path, route, w, r)
-
-			if response.isRedirect {
-				http.Redirect(w, r, response.redirectTo, response.HTTPStatus)
-				if logger != nil && app.LogAccess {
-					logger.Printf(
-						"access %p %s %s%s handle %s %p result %v %v",
-						r, r.Method, path, q, route.Path, route.Handler,
-						response.HTTPStatus, time.Since(start))
-				}
-				return
-			}
+		app.logAccess(r, nil, status, start)
+		return
+	}
 
-			// Set the response headers
-			for k, v := range response.Headers {
-				w.Header().Set(k, v)
-			}
+	if route.limits != nil && app.ClientIDFactory == nil {
+		if logger != nil {
+			logger.Printf(
+				"%s : %s\n", "warning",
+				"RouteLimit set but no ClientIDFactory")
+		}
+	} else {
+		if res := route.limit(app.ClientIDFactory, r); !res.Allowed {
+			app.writeRateLimited(w, res)
+			app.logAccess(r, route, http.StatusTooManyRequests, start)
+			return
+		}
+	}
+
+	handler := http.Handler(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			app.serveRoute(w, r, path, route, fields, start)
+		}))
+	handler = wrapHTTP(route.middleware(), handler)
+	handler = wrapHTTP(app.Middleware, handler)
+	handler.ServeHTTP(w, r)
+}
+
+// serveRoute processes a request matching the Route and sends the
+// Response. It is wrapped by the WrapHTTP functions of the Middleware
+// applied to the App and the Route.
+func (app *App) serveRoute(w http.ResponseWriter, r *http.Request,
+	path string, route *Route, fields map[string]string, start time.Time) {
+	// Temporary files from a multipart form are removed once the
+	// Terminate Middleware has run.
+	defer cleanupForm(r)
+
+	encode, contentType, request, response :=
+		app.process(path, route, fields, w, r)
+
+	if response.isRedirect {
+		http.Redirect(w, r, response.redirectTo, response.HTTPStatus)
+		app.logAccess(r, route, response.HTTPStatus, start)
+		return
+	}
+
+	if response.content != nil {
+		status := app.serveContent(w, r, response)
+		app.terminate(r, route, request, response)
+		app.logAccess(r, route, status, start)
+		return
+	}
+
+	if response.upgrade != nil {
+		status := app.serveWebSocket(w, r, response)
+		app.terminate(r, route, request, response)
+		app.logAccess(r, route, status, start)
+		return
+	}
+
+	// Set the response headers
+	for k, v := range response.Headers {
+		w.Header().Set(k, v)
+	}
+
+	// Set the content type
+	w.Header().Set("Content-Type", contentType)
+
+	// Output the response. Responses to HEAD requests carry the
+	// headers of the response without the body. The length of a
+	// streaming response is not known in advance.
+	status := response.HTTPStatus
+	aborted := false
+	if r.Method == http.MethodHead {
+		counter := &countingWriter{}
+		if response.stream == nil && encode(counter) == nil {
+			w.Header().Set(
+				"Content-Length", strconv.FormatInt(counter.n, 10))
+		}
+		w.WriteHeader(status)
+	} else {
+		status, aborted = app.writeBody(w, r, status, encode)
+	}
 
-			// Set the content type
-			w.Header().Set("Content-Type", contentType)
+	app.terminate(r, route, request, response)
 
-			// Set the HTTP status code
-			w.WriteHeader(response.HTTPStatus)
+	app.logAccess(r, route, status, start)
 
-			// Output the response
-			w.Write([]byte(serialized))
+	if aborted {
+		panic(http.ErrAbortHandler)
+	}
+}
 
-			// Process any "terminate" middleware
-			for _, mw := range route.Middleware {
-				if mw.Terminate != nil {
-					mw.Terminate(request, response)
-				}
+// writeBody writes the specified status followed by the body written
+// by encode, returning the status that was sent. The status is only
+// written once encode starts writing the body, so if encode fails
+// before then, a 500 Internal Server Error is sent instead. If encode
+// fails after the body has been partially written, the error is logged
+// and true is returned to indicate that the connection should be
+// aborted so that the client does not mistake the body for a complete
+// response.
+func (app *App) writeBody(w http.ResponseWriter, r *http.Request,
+	status int, encode func(io.Writer) error) (int, bool) {
+	bw := &bodyWriter{ResponseWriter: w, status: status}
+	err := encode(bw)
+	if err == nil {
+		if !bw.wroteHeader {
+			w.WriteHeader(status)
+		}
+		return status, false
+	}
+
+	// The client has gone away, so there is nothing more to do.
+	if bw.err != nil || r.Context().Err() != nil {
+		return status, false
+	}
+
+	message := err.Error()
+	if !bw.wroteHeader {
+		app.writeError(w, http.StatusInternalServerError, message)
+		return http.StatusInternalServerError, false
+	}
+
+	if logger != nil {
+		logger.Printf("error : %v\n", message)
+	}
+
+	return status, true
+}
+
+// bodyWriter writes the status of a response to the underlying
+// http.ResponseWriter when the body is first written, and records
+// any error returned while writing it.
+type bodyWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	err         error
+}
+
+// Write writes the status if it has not yet been written, followed by
+// the data.
+func (bw *bodyWriter) Write(data []byte) (int, error) {
+	if !bw.wroteHeader {
+		bw.wroteHeader = true
+		bw.ResponseWriter.WriteHeader(bw.status)
+	}
+
+	n, err := bw.ResponseWriter.Write(data)
+	if err != nil {
+		bw.err = err
+	}
+
+	return n, err
+}
+
+// Flush writes the status if it has not yet been written, and then
+// sends any buffered data to the client.
+func (bw *bodyWriter) Flush() {
+	if !bw.wroteHeader {
+		bw.wroteHeader = true
+		bw.ResponseWriter.WriteHeader(bw.status)
+	}
+
+	if flusher, isFlusher := bw.ResponseWriter.(http.Flusher); isFlusher {
+		flusher.Flush()
+	}
+}
+
+// countingWriter discards everything written to it, counting the
+// number of bytes.
+type countingWriter struct {
+	n int64
+}
+
+// Write counts the length of the data.
+func (cw *countingWriter) Write(data []byte) (int, error) {
+	cw.n += int64(len(data))
+	return len(data), nil
+}
+
+// invoke passes the Request to the specified Handler and returns the
+// Response it generates. If the Handler panics, or returns no
+// Response, a 500 Internal Server Error Response is returned instead.
+// A Handler stopped by request.MustBind(dst) returns the Response for
+// the error that stopped it.
+func (app *App) invoke(handler Handler, request *Request) (
+	response *Response) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			if recovered == http.ErrAbortHandler {
+				panic(recovered)
 			}
-			for _, mw := range app.Middleware {
-				if mw.Terminate != nil {
-					mw.Terminate(request, response)
-				}
+			if abort, isAbort := recovered.(abortResponse); isAbort {
+				response = abort.response
+				return
 			}
+			app.recoverPanic(request.HTTPRequest, recovered)
+			response = NewResponse(
+				http.StatusInternalServerError,
+				map[string]interface{}{
+					"error": "internal server error",
+				},
+			)
+		}
+	}()
+
+	response = handler(request)
+	if response == nil {
+		panic(fmt.Errorf(
+			"handler for route %v %v returned no response",
+			request.Route.Method, request.Route.Path))
+	}
+
+	return response
+}
 
-			if logger != nil && app.LogAccess {
-				logger.Printf(
-					"access %p %s %s%s handle %s %p result %v %v",
-					r, r.Method, path, q, route.Path, route.Handler,
-					response.HTTPStatus, time.Since(start))
+// terminate runs the Terminate functions from the Middleware applied
+// to the Route and the App once the response has been sent. Since the
+// response has already been sent, any panic is only logged. The
+// Request is nil if the input data could not be parsed.
+func (app *App) terminate(
+	r *http.Request, route *Route, request *Request, response *Response,
+) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			if recovered == http.ErrAbortHandler {
+				panic(recovered)
 			}
-			return
+			app.recoverPanic(r, recovered)
+		}
+	}()
+
+	// Process any "terminate" middleware
+	for _, mw := range route.middleware() {
+		if mw.Terminate != nil {
+			mw.Terminate(request, response)
+		}
+	}
+	for _, mw := range app.Middleware {
+		if mw.Terminate != nil {
+			mw.Terminate(request, response)
 		}
 	}
+}
 
-	w.WriteHeader(http.StatusNotFound)
-	if logger != nil && app.LogAccess {
+// recoverPanic logs a recovered panic along with its stack trace and
+// passes it to the PanicHandler of the App, if one is set.
+func (app *App) recoverPanic(r *http.Request, recovered interface{}) {
+	stack := debug.Stack()
+	if logger != nil {
 		logger.Printf(
-			"access %p %s %s%s handle nil 0x0000000 result 404 %v",
-			r, r.Method, path, q, time.Since(start))
+			"panic : %s %s: %v\n%s", r.Method, r.URL.Path, recovered, stack)
+	}
+
+	if app.PanicHandler != nil {
+		app.PanicHandler(r, recovered, stack)
 	}
-	return
 }
 
-// rateLimit will process any potentially configured rate limits for
-// the specified request.
-func (app *App) rateLimit(w http.ResponseWriter, r *http.Request) {
-	if app.GlobalLimit != nil {
-		if !app.GlobalLimit.Allow() {
-			w.WriteHeader(http.StatusTooManyRequests)
-			return
-		}
+// writeError writes a response with the specified HTTP status and an
+// error message serialized using the Serializer for the App.
+func (app *App) writeError(w http.ResponseWriter, status int, message string) {
+	serializer := DefaultSerializer
+	if app.Serializer != nil {
+		serializer = app.Serializer
 	}
 
-	if app.ClientLimit != nil {
-		if app.ClientIDFactory != nil {
-			clientid := app.ClientIDFactory(r)
+	contentType := serializer.ContentType
+	serialized, err := serializer.Serialize(map[string]interface{}{
+		"error": message,
+	})
+	if err != nil {
+		serialized = message
+		contentType = "text/plain"
+	}
 
-			if app.clientLimits == nil {
-				app.clientLimits = map[string]*rate.Limiter{}
-			}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	w.Write([]byte(serialized))
+}
 
-			if _, exists := app.clientLimits[clientid]; !exists {
-				app.clientLimits[clientid] = rate.NewLimiter(
-					app.ClientLimit.Limit(), app.ClientLimit.Burst())
-			}
+// logAccess will log an ACCESS message for the specified request if
+// the App has been configured to do so. The route can be nil if no
+// Route was found for the request.
+func (app *App) logAccess(
+	r *http.Request, route *Route, status int, start time.Time,
+) {
+	if logger == nil || !app.LogAccess {
+		return
+	}
 
-			if !app.clientLimits[clientid].Allow() {
-				w.WriteHeader(http.StatusTooManyRequests)
-				return
+	q := r.URL.RawQuery
+	if q != "" {
+		q = "?" + q
+	}
+	path := r.URL.Path[1:]
+
+	if route == nil {
+		logger.Printf(
+			"access %p %s %s%s handle nil 0x0000000 result %v %v",
+			r, r.Method, path, q, status, time.Since(start))
+		return
+	}
+
+	logger.Printf(
+		"access %p %s %s%s handle %s %p result %v %v",
+		r, r.Method, path, q, route.Path, route.Handler,
+		status, time.Since(start))
+}
+
+// rateLimit will process any potentially configured rate limits for
+// the specified request. If the request is not allowed, a 429 Too
+// Many Requests response is written and false is returned.
+func (app *App) rateLimit(w http.ResponseWriter, r *http.Request) bool {
+	if res := takeFrom(r.Context(), app.globalLimits, ""); !res.Allowed {
+		app.writeRateLimited(w, res)
+		return false
+	}
+
+	if app.clientLimits != nil {
+		if app.ClientIDFactory != nil {
+			res := takeFrom(
+				r.Context(), app.clientLimits, app.ClientIDFactory(r))
+			if !res.Allowed {
+				app.writeRateLimited(w, res)
+				return false
 			}
 		} else {
 			if logger != nil {
@@ -300,6 +707,15 @@ func (app *App) rateLimit(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 	}
+
+	return true
+}
+
+// writeRateLimited writes a 429 Too Many Requests response describing
+// the rate limit that was exceeded.
+func (app *App) writeRateLimited(w http.ResponseWriter, res RateLimitResult) {
+	res.setHeaders(w.Header())
+	app.writeError(w, http.StatusTooManyRequests, "too many requests")
 }
 
 // AddMiddleware adds the specified Middleware to the App.
@@ -309,64 +725,24 @@ func (app *App) AddMiddleware(mw Middleware) {
 
 // process will process the incoming data through the configured
 // serializers, use the controller bound to the route to process the
-// request and return the serialized response, the value for the
-// content type header value, and the response object.
+// request and return a function that writes the serialized response,
+// the value for the content type header value, and the response
+// object.
 // If an error is encountered while serializing or deserializing the
 // data 400 or 500 HTTP response code will be returned respectively.
+// Serializers with a StreamSerializer write the response as it is
+// encoded, so errors encountered while doing so are returned by the
+// function that writes it instead.
 func (app *App) process(path string, route *Route,
-	w http.ResponseWriter, r *http.Request) (
-	string, string, *Request, *Response) {
-	// Retrieve the body
-	var body []byte
-	var bodyErr error
-	if r.ContentLength > 0 {
-		body, bodyErr = ioutil.ReadAll(r.Body)
-		if bodyErr != nil {
-			serializer := DefaultSerializer
-			if app.Serializer != nil {
-				serializer = app.Serializer
-			}
-			serialized, _ := serializer.Serialize(
-				map[string]interface{}{
-					"error": fmt.Sprintf("%v", bodyErr),
-				},
-			)
-			contentType := serializer.ContentType
-
-			return serialized, contentType, nil, &Response{
-				HTTPStatus: 500,
-			}
-		}
-	}
-
+	fields map[string]string, w http.ResponseWriter, r *http.Request) (
+	func(io.Writer) error, string, *Request, *Response) {
 	// Deserialize input data
-	data := map[string]interface{}{}
-	if len(body) > 0 {
-		var deserr error
-		if route.Serializer != nil {
-			data, deserr = route.Serializer.Deserialize(string(body))
-		} else if app.Serializer != nil {
-			data, deserr = app.Serializer.Deserialize(string(body))
-		} else {
-			data, deserr = DefaultSerializer.Deserialize(string(body))
-		}
+	data, files, status, err := app.readInput(route, w, r)
+	if err != nil {
+		encode, contentType := app.errorBody(err.Error())
 
-		if deserr != nil {
-			serializer := DefaultSerializer
-			if app.Serializer != nil {
-				serializer = app.Serializer
-			}
-			serialized, _ := serializer.Serialize(
-				map[string]interface{}{
-					"error": fmt.Sprintf(
-						"failed to parse input data: %v", deserr),
-				},
-			)
-			contentType := serializer.ContentType
-
-			return serialized, contentType, nil, &Response{
-				HTTPStatus: 400,
-			}
+		return encode, contentType, nil, &Response{
+			HTTPStatus: status,
 		}
 	}
 
@@ -378,69 +754,227 @@ func (app *App) process(path string, route *Route,
 		Headers:     requestHeaders1D(r.Header),
 		Params:      requestQuery1D(r.URL.Query()),
 		HTTPRequest: r,
+		fields:      fields,
+		files:       files,
+		app:         app,
 	}
 
-	// Process any "before" middleware
-	for _, mw := range app.Middleware {
-		if mw.Before != nil {
-			mw.Before(&request)
+	response := app.invoke(
+		wrapMiddleware(app.Middleware, route.handle), &request)
+
+	// Redirects, files and WebSocket upgrades are sent without a
+	// Serializer.
+	if response.isRedirect || response.content != nil ||
+		response.upgrade != nil {
+		return nil, "", &request, response
+	}
+
+	if response.stream != nil {
+		contentType := response.Headers["Content-Type"]
+		if contentType == "" {
+			contentType = "application/octet-stream"
 		}
+
+		return app.streamBody(r, response.stream), contentType,
+			&request, response
 	}
 
-	response := route.handle(&request)
+	// Select the serializer for the response
+	output := response.Serializer
+	contentType := ""
+	if output != nil {
+		contentType = output.ContentType
+	} else {
+		var acceptable bool
+		output, contentType, acceptable = app.outputSerializer(route, w, r)
+		if !acceptable {
+			encode, contentType := app.errorBody(
+				"none of the accepted media types are available")
+
+			return encode, contentType, &request, &Response{
+				HTTPStatus: http.StatusNotAcceptable,
+			}
+		}
+	}
 
-	// Process any "after" middleware
-	for _, mw := range app.Middleware {
-		if mw.After != nil {
-			mw.After(response)
+	if output.Stream != nil {
+		stream, data := output.Stream, response.Data
+		encode := func(w io.Writer) error {
+			if err := stream.Encode(w, data); err != nil {
+				return fmt.Errorf(
+					"failed to serialize output data: %w", err)
+			}
+			return nil
 		}
+
+		return encode, contentType, &request, response
 	}
 
-	var serialized string
-	var err error
-	var contentType string
-
-	if !response.isRedirect {
-		// Serialize the response
-		if response.Serializer != nil {
-			serialized, err = response.Serializer.Serialize(response.Data)
-			contentType = response.Serializer.ContentType
-		} else if route.Serializer != nil {
-			serialized, err = route.Serializer.Serialize(response.Data)
-			contentType = route.Serializer.ContentType
-		} else if app.Serializer != nil {
-			serialized, err = app.Serializer.Serialize(response.Data)
-			contentType = app.Serializer.ContentType
-		} else {
-			serialized, err = DefaultSerializer.Serialize(response.Data)
-			contentType = DefaultSerializer.ContentType
+	// Serialize the response
+	serialized, err := output.serialize(response.Data)
+
+	// Handle any serialization errors
+	if err != nil {
+		encode, contentType := app.errorBody(fmt.Sprintf(
+			"failed to serialize output data: %v", err))
+
+		return encode, contentType, &request, &Response{
+			HTTPStatus: 500,
+		}
+	}
+
+	return writeString(serialized), contentType, &request, response
+}
+
+// readInput reads the input data for the Request from the request
+// body, along with any files uploaded in a multipart form. If the
+// input data can not be read, the HTTP status to respond with is
+// returned along with an error describing the problem.
+func (app *App) readInput(route *Route, w http.ResponseWriter,
+	r *http.Request) (map[string]interface{}, []*FormFile, int, error) {
+	data := map[string]interface{}{}
+
+	// The length of the body is -1 if it is unknown, such as when the
+	// body is sent using chunked transfer encoding.
+	if r.ContentLength == 0 || r.Body == nil || r.Body == http.NoBody {
+		return data, nil, 0, nil
+	}
+
+	limit := app.maxBodyBytes(route)
+	if limit > 0 {
+		if r.ContentLength > limit {
+			return nil, nil, http.StatusRequestEntityTooLarge,
+				bodyTooLarge(limit)
 		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+	}
+
+	body := &bodyReader{ReadCloser: r.Body}
+	r.Body = body
+
+	// fail returns the specified error along with the status, unless
+	// the error was caused by the body exceeding the limit or failing
+	// to be read. The reason the body could not be read, such as a
+	// body that is not valid gzip, is not sent to the client.
+	fail := func(status int, err error) (
+		map[string]interface{}, []*FormFile, int, error) {
+		var maxErr *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxErr), errors.As(body.err, &maxErr):
+			return nil, nil, http.StatusRequestEntityTooLarge,
+				bodyTooLarge(limit)
+		case body.err != nil:
+			return nil, nil, http.StatusBadRequest, errReadBody
+		}
+
+		return nil, nil, status, err
+	}
 
-		// Handle any serialization errors
+	if isForm(r) {
+		data, files, err := app.parseForm(r)
 		if err != nil {
-			var lastser error
-			serializer := DefaultSerializer
-			if app.Serializer != nil {
-				serializer = app.Serializer
-			}
-			serialized, lastser = serializer.Serialize(
-				map[string]interface{}{
-					"error": fmt.Sprintf(
-						"failed to serialize output data: %v", err),
-				},
-			)
-			if lastser != nil {
-				serialized = fmt.Sprintf(
-					"failed to serialize output data: %v", err)
-				contentType = "text/plain"
-			} else {
-				contentType = serializer.ContentType
-			}
-			response = &Response{
-				HTTPStatus: 500,
-			}
+			return fail(http.StatusBadRequest, fmt.Errorf(
+				"failed to parse input data: %w", err))
+		}
+
+		return data, files, 0, nil
+	}
+
+	input, supported := app.inputSerializer(route, r)
+	if !supported {
+		return nil, nil, http.StatusUnsupportedMediaType, fmt.Errorf(
+			"unsupported media type %v", r.Header.Get("Content-Type"))
+	}
+
+	var err error
+	if input.Stream != nil {
+		err = input.Stream.Decode(body, &data)
+		if err == io.EOF {
+			err = nil
+		}
+	} else {
+		raw, readErr := ioutil.ReadAll(body)
+		if readErr != nil {
+			return fail(http.StatusBadRequest, readErr)
+		}
+
+		if len(raw) > 0 {
+			data, err = input.Deserialize(string(raw))
 		}
 	}
 
-	return serialized, contentType, &request, response
+	if err != nil {
+		return fail(http.StatusBadRequest, fmt.Errorf(
+			"failed to parse input data: %w", err))
+	}
+
+	return data, nil, 0, nil
+}
+
+// errReadBody is returned when the request body can not be read.
+var errReadBody = errors.New("failed to read request body")
+
+// bodyReader records the error returned when reading the request body
+// fails, so that it can be told apart from invalid input data.
+type bodyReader struct {
+	io.ReadCloser
+	err error
+}
+
+// Read reads from the request body, recording any error other than
+// io.EOF.
+func (br *bodyReader) Read(data []byte) (int, error) {
+	n, err := br.ReadCloser.Read(data)
+	if err != nil && err != io.EOF {
+		br.err = err
+	}
+
+	return n, err
+}
+
+// maxBodyBytes returns the maximum size of the request body for the
+// Route. A negative value means the size is not limited.
+func (app *App) maxBodyBytes(route *Route) int64 {
+	switch {
+	case route.MaxBodyBytes != 0:
+		return route.MaxBodyBytes
+	case app.MaxBodyBytes != 0:
+		return app.MaxBodyBytes
+	}
+
+	return DefaultMaxBodyBytes
+}
+
+// bodyTooLarge returns the error used when the request body exceeds
+// the specified limit.
+func bodyTooLarge(limit int64) error {
+	return fmt.Errorf("request body exceeds the limit of %v bytes", limit)
+}
+
+// errorBody returns a function that writes the serialized error
+// message, along with its content type. The error is serialized using
+// the Serializer of the App, or the DefaultSerializer. If that fails,
+// the message is written as plain text.
+func (app *App) errorBody(message string) (func(io.Writer) error, string) {
+	serializer := DefaultSerializer
+	if app.Serializer != nil {
+		serializer = app.Serializer
+	}
+
+	serialized, err := serializer.Serialize(map[string]interface{}{
+		"error": message,
+	})
+	if err != nil {
+		return writeString(message), "text/plain"
+	}
+
+	return writeString(serialized), serializer.ContentType
+}
+
+// writeString returns a function that writes the specified string.
+func writeString(s string) func(io.Writer) error {
+	return func(w io.Writer) error {
+		_, err := io.WriteString(w, s)
+		return err
+	}
 }
@@ -0,0 +1,111 @@
+package galago
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// jsonBody returns a JSON request body of exactly size bytes.
+func jsonBody(size int) string {
+	return `{"data":"` + strings.Repeat("x", size-len(`{"data":""}`)) + `"}`
+}
+
+// multipartBody returns a multipart form holding a file of the
+// specified size, along with its content type.
+func multipartBody(t *testing.T, size int) (string, string) {
+	t.Helper()
+
+	var b bytes.Buffer
+	mw := multipart.NewWriter(&b)
+	fw, err := mw.CreateFormFile("upload", "upload.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write(bytes.Repeat([]byte("x"), size))
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return b.String(), mw.FormDataContentType()
+}
+
+func TestMaxBodyBytes(t *testing.T) {
+	const limit = 1024
+
+	form, formType := multipartBody(t, 2*limit)
+
+	tests := []struct {
+		name        string
+		appLimit    int64
+		routeLimit  int64
+		body        string
+		contentType string
+		chunked     bool
+		status      int
+	}{
+		{"within limit", limit, 0,
+			jsonBody(limit), "application/json", false, http.StatusOK},
+		{"content length over limit", limit, 0,
+			jsonBody(limit + 1), "application/json", false,
+			http.StatusRequestEntityTooLarge},
+		{"chunked within limit", limit, 0,
+			jsonBody(limit), "application/json", true, http.StatusOK},
+		{"chunked over limit", limit, 0,
+			jsonBody(limit + 1), "application/json", true,
+			http.StatusRequestEntityTooLarge},
+		{"chunked text over limit", limit, 0,
+			strings.Repeat("x", limit+1), "text/plain", true,
+			http.StatusRequestEntityTooLarge},
+		{"multipart over limit", limit, 0,
+			form, formType, false, http.StatusRequestEntityTooLarge},
+		{"chunked multipart over limit", limit, 0,
+			form, formType, true, http.StatusRequestEntityTooLarge},
+		{"default limit", 0, 0,
+			jsonBody(DefaultMaxBodyBytes + 1), "application/json", true,
+			http.StatusRequestEntityTooLarge},
+		{"route raises limit", limit, 4 * limit,
+			form, formType, true, http.StatusOK},
+		{"route lowers limit", 4 * limit, limit,
+			form, formType, true, http.StatusRequestEntityTooLarge},
+		{"route removes limit", limit, -1,
+			jsonBody(DefaultMaxBodyBytes + 1), "application/json", true,
+			http.StatusOK},
+		{"app removes limit", -1, 0,
+			jsonBody(DefaultMaxBodyBytes + 1), "application/json", false,
+			http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			route := NewRoute(http.MethodPost, "upload", func(Request) *Response {
+				return NewResponse(http.StatusOK, nil)
+			})
+			route.MaxBodyBytes = test.routeLimit
+			if test.contentType == "text/plain" {
+				route.Serializer = TextSerializer()
+			}
+
+			app := &App{MaxBodyBytes: test.appLimit}
+			app.AddController(NewController().AddRoute(route))
+
+			r := httptest.NewRequest(
+				http.MethodPost, "/upload", strings.NewReader(test.body))
+			r.Header.Set("Content-Type", test.contentType)
+			if test.chunked {
+				r.ContentLength = -1
+				r.TransferEncoding = []string{"chunked"}
+			}
+			w := httptest.NewRecorder()
+			app.ServeHTTP(w, r)
+
+			if w.Code != test.status {
+				t.Errorf("expected status %v, got %v: %v",
+					test.status, w.Code, w.Body.String())
+			}
+		})
+	}
+}
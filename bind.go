@@ -0,0 +1,584 @@
+package galago
+
+import (
+	"encoding"
+	"fmt"
+	"math"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FieldError describes a single value from a Request that could not
+// be bound to a field of a struct.
+type FieldError struct {
+	// The name of the value as it appears in the Request. Values
+	// nested within the Request Data are delimited with a period,
+	// like the paths used with request.GetData(path).
+	Field string
+	// Where the value was read from. One of "body", "query", "path"
+	// or "header".
+	Source string
+	// The validation rule that the value failed, such as "required"
+	// or "max". This is empty if the value could not be bound.
+	Rule string
+	// A description of what was wrong with the value.
+	Message string
+}
+
+// Error returns a description of the FieldError.
+func (err FieldError) Error() string {
+	return fmt.Sprintf("%s %s: %s", err.Source, err.Field, err.Message)
+}
+
+// BindError is returned by request.Bind(dst) when one or more values
+// from the Request could not be converted to the type of the field
+// they were bound to.
+type BindError struct {
+	// The errors for each value that could not be bound.
+	Errors []FieldError
+}
+
+// Error returns a description of every FieldError in the BindError.
+func (err *BindError) Error() string {
+	messages := make([]string, len(err.Errors))
+	for i, fieldErr := range err.Errors {
+		messages[i] = fieldErr.Error()
+	}
+
+	return "invalid request: " + strings.Join(messages, "; ")
+}
+
+// Response creates a 400 Bad Request Response describing each
+// FieldError in the BindError.
+func (err *BindError) Response() *Response {
+	return NewResponse(http.StatusBadRequest, map[string]interface{}{
+		"error":  "invalid request",
+		"fields": fieldErrorData(err.Errors),
+	})
+}
+
+// fieldErrorData converts the specified FieldErrors into a form that
+// can be serialized by any Serializer.
+func fieldErrorData(errs []FieldError) []interface{} {
+	res := make([]interface{}, len(errs))
+	for i, err := range errs {
+		data := map[string]interface{}{
+			"field":   err.Field,
+			"source":  err.Source,
+			"message": err.Message,
+		}
+		if err.Rule != "" {
+			data["rule"] = err.Rule
+		}
+		res[i] = data
+	}
+
+	return res
+}
+
+var (
+	durationType        = reflect.TypeOf(time.Duration(0))
+	timeType            = reflect.TypeOf(time.Time{})
+	textUnmarshalerType = reflect.TypeOf(
+		(*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// Bind fills the struct pointed to by dst using the values from the
+// Request. The source of the value for each field is selected using
+// its struct tags.
+//
+//   - `json:"name"` binds the value at the key in the Request Data.
+//     Nested structs, slices and maps are bound from nested data.
+//   - `query:"name"` binds the value of the Query Parameter.
+//   - `path:"name"` binds the value of the Route Parameter.
+//   - `header:"name"` binds the value of the Request Header.
+//
+// Exported fields without any of these tags are bound from the
+// Request Data using the name of the field, matched without regard to
+// case. If a field has several tags, the sources are applied in the
+// order listed above, with any value found overriding the last.
+//
+// Values are converted to the type of the field. Strings are parsed
+// into numbers, booleans, time.Duration values, time.Time values in
+// RFC 3339 format and any type implementing encoding.TextUnmarshaler.
+// Slices bound from a Query Parameter or Header receive every value
+// present in the Request.
+//
+// If any value can not be converted, the remaining fields are still
+// bound and a *BindError describing each failure is returned. Its
+// Response() function can be used to respond with a 400 Bad Request.
+//
+// Once every value has been bound, the struct is checked against the
+// rules in its `validate` tags using request.Validate(dst).
+func (request *Request) Bind(dst interface{}) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr || v.IsNil() ||
+		v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf(
+			"bind destination must be a non-nil pointer to a struct, "+
+				"got %T", dst)
+	}
+
+	b := binder{request: request}
+	b.bindStruct(v.Elem(), request.Data, "", true)
+	if len(b.errs) > 0 {
+		return &BindError{Errors: b.errs}
+	}
+
+	return request.Validate(dst)
+}
+
+// MustBind is like Bind, except that when the Request can not be
+// bound or fails validation, the Handler is stopped and the client
+// receives the Response of the *BindError or *ValidationError. Any
+// other error is treated as a panic.
+func (request *Request) MustBind(dst interface{}) {
+	err := request.Bind(dst)
+	switch err := err.(type) {
+	case nil:
+		return
+	case *BindError:
+		panic(abortResponse{err.Response()})
+	case *ValidationError:
+		panic(abortResponse{err.Response()})
+	}
+
+	panic(err)
+}
+
+// abortResponse is used by MustBind to stop a Handler with the
+// specified Response.
+type abortResponse struct {
+	response *Response
+}
+
+// binder binds the values from a Request to a struct, collecting an
+// error for every value that can not be converted.
+type binder struct {
+	request *Request
+	errs    []FieldError
+}
+
+// fail records a FieldError.
+func (b *binder) fail(source, field, message string) {
+	b.errs = append(b.errs, FieldError{
+		Field: field, Source: source, Message: message,
+	})
+}
+
+// bindStruct binds the fields of the struct v. Only the Request Data
+// is used unless top is true, since nested structs can only be bound
+// from nested data.
+func (b *binder) bindStruct(
+	v reflect.Value, data map[string]interface{}, prefix string, top bool,
+) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		fv := v.Field(i)
+
+		jsonName, hasJSON := sf.Tag.Lookup("json")
+		jsonName = strings.Split(jsonName, ",")[0]
+		query, hasQuery := sf.Tag.Lookup("query")
+		path, hasPath := sf.Tag.Lookup("path")
+		header, hasHeader := sf.Tag.Lookup("header")
+		explicit := hasJSON || hasQuery || hasPath || hasHeader
+
+		// Embedded structs without tags have their fields promoted.
+		if sf.Anonymous && !explicit {
+			ft := sf.Type
+			if ft.Kind() == reflect.Ptr {
+				if sf.PkgPath != "" {
+					continue
+				}
+				ft = ft.Elem()
+				if ft.Kind() == reflect.Struct && fv.IsNil() {
+					fv.Set(reflect.New(ft))
+				}
+				fv = fv.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				b.bindStruct(fv, data, prefix, top)
+				continue
+			}
+		}
+
+		if sf.PkgPath != "" {
+			continue
+		}
+
+		if jsonName != "-" && (hasJSON || !explicit) {
+			if jsonName == "" {
+				jsonName = sf.Name
+			}
+			if raw, exists := lookupData(data, jsonName); exists {
+				b.bindData(fv, raw, prefix+jsonName)
+			}
+		}
+
+		if !top {
+			continue
+		}
+
+		if hasQuery && query != "-" {
+			b.bindStrings(fv, b.queryValues(query), "query", query)
+		}
+		if hasPath && path != "-" {
+			if val := b.request.GetField(path); val != nil {
+				b.bindStrings(fv, []string{*val}, "path", path)
+			}
+		}
+		if hasHeader && header != "-" {
+			b.bindStrings(fv, b.headerValues(header), "header", header)
+		}
+	}
+}
+
+// queryValues returns every value for the specified Query Parameter.
+func (b *binder) queryValues(key string) []string {
+	if r := b.request.HTTPRequest; r != nil && r.URL != nil {
+		return r.URL.Query()[key]
+	}
+	if val := b.request.GetQuery(key); val != nil {
+		return []string{*val}
+	}
+
+	return nil
+}
+
+// headerValues returns every value for the specified Request Header.
+func (b *binder) headerValues(key string) []string {
+	if r := b.request.HTTPRequest; r != nil {
+		return r.Header.Values(key)
+	}
+	if val := b.request.GetHeader(key); val != nil {
+		return []string{*val}
+	}
+
+	return nil
+}
+
+// lookupData retrieves the value for the specified key from the data,
+// falling back to a match without regard to case.
+func lookupData(data map[string]interface{}, key string) (interface{}, bool) {
+	if val, exists := data[key]; exists {
+		return val, true
+	}
+	for k, val := range data {
+		if strings.EqualFold(k, key) {
+			return val, true
+		}
+	}
+
+	return nil, false
+}
+
+// bindStrings binds the string values read from the Query Parameters,
+// Route Parameters or Headers to v. If there are no values, v is left
+// unchanged.
+func (b *binder) bindStrings(
+	v reflect.Value, values []string, source, field string,
+) {
+	if len(values) == 0 {
+		return
+	}
+
+	if v.Kind() == reflect.Slice && !isTextType(v.Type()) {
+		slice := reflect.MakeSlice(v.Type(), len(values), len(values))
+		failed := false
+		for i, s := range values {
+			if err := setString(slice.Index(i), s); err != nil {
+				b.fail(source, field, err.Error())
+				failed = true
+			}
+		}
+		if !failed {
+			v.Set(slice)
+		}
+		return
+	}
+
+	if err := setString(v, values[0]); err != nil {
+		b.fail(source, field, err.Error())
+	}
+}
+
+// bindData binds a value from the deserialized Request Data to v.
+func (b *binder) bindData(v reflect.Value, raw interface{}, field string) {
+	if raw == nil {
+		return
+	}
+
+	fail := func() {
+		b.fail("body", field, fmt.Sprintf(
+			"expected %s, got %s", typeName(v.Type()), dataTypeName(raw)))
+	}
+
+	if v.Kind() == reflect.Ptr {
+		elem := reflect.New(v.Type().Elem())
+		before := len(b.errs)
+		b.bindData(elem.Elem(), raw, field)
+		if len(b.errs) == before {
+			v.Set(elem)
+		}
+		return
+	}
+
+	// A single form value is bound to a slice as one item.
+	if _, isString := raw.(string); isString &&
+		v.Kind() == reflect.Slice && !isTextType(v.Type()) {
+		raw = []interface{}{raw}
+	}
+
+	if s, isString := raw.(string); isString && v.Kind() != reflect.Interface {
+		if err := setString(v, s); err != nil {
+			b.fail("body", field, err.Error())
+		}
+		return
+	}
+
+	rv := reflect.ValueOf(raw)
+	switch v.Kind() {
+	case reflect.Bool:
+		if rv.Kind() != reflect.Bool {
+			fail()
+			return
+		}
+		v.SetBool(rv.Bool())
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Int64:
+		f, isNumber := toFloat(rv)
+		if !isNumber || f != math.Trunc(f) || v.OverflowInt(int64(f)) {
+			fail()
+			return
+		}
+		v.SetInt(int64(f))
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64, reflect.Uintptr:
+		f, isNumber := toFloat(rv)
+		if !isNumber || f != math.Trunc(f) || f < 0 ||
+			v.OverflowUint(uint64(f)) {
+			fail()
+			return
+		}
+		v.SetUint(uint64(f))
+
+	case reflect.Float32, reflect.Float64:
+		f, isNumber := toFloat(rv)
+		if !isNumber || v.OverflowFloat(f) {
+			fail()
+			return
+		}
+		v.SetFloat(f)
+
+	case reflect.Struct:
+		data, isMap := raw.(map[string]interface{})
+		if !isMap || isTextType(v.Type()) {
+			fail()
+			return
+		}
+		b.bindStruct(v, data, field+".", false)
+
+	case reflect.Map:
+		data, isMap := raw.(map[string]interface{})
+		if !isMap || v.Type().Key().Kind() != reflect.String {
+			fail()
+			return
+		}
+		m := reflect.MakeMapWithSize(v.Type(), len(data))
+		for key, val := range data {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			b.bindData(elem, val, field+"."+key)
+			m.SetMapIndex(reflect.ValueOf(key).Convert(v.Type().Key()), elem)
+		}
+		v.Set(m)
+
+	case reflect.Slice, reflect.Array:
+		items, isSlice := raw.([]interface{})
+		if !isSlice {
+			fail()
+			return
+		}
+		if v.Kind() == reflect.Array && len(items) > v.Len() {
+			fail()
+			return
+		}
+		res := v
+		if v.Kind() == reflect.Slice {
+			res = reflect.MakeSlice(v.Type(), len(items), len(items))
+		}
+		for i, item := range items {
+			b.bindData(res.Index(i), item, field+"."+strconv.Itoa(i))
+		}
+		v.Set(res)
+
+	default:
+		if !rv.Type().AssignableTo(v.Type()) {
+			fail()
+			return
+		}
+		v.Set(rv)
+	}
+}
+
+// setString parses the string s into v according to the type of v.
+func setString(v reflect.Value, s string) error {
+	if v.Kind() == reflect.Ptr {
+		elem := reflect.New(v.Type().Elem())
+		if err := setString(elem.Elem(), s); err != nil {
+			return err
+		}
+		v.Set(elem)
+		return nil
+	}
+
+	invalid := func() error {
+		return fmt.Errorf(
+			"invalid value %q, expected %s", s, typeName(v.Type()))
+	}
+
+	if v.CanAddr() && v.Addr().Type().Implements(textUnmarshalerType) {
+		err := v.Addr().Interface().(encoding.TextUnmarshaler).
+			UnmarshalText([]byte(s))
+		if err != nil {
+			return invalid()
+		}
+		return nil
+	}
+
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return invalid()
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+
+	case reflect.Interface:
+		if v.NumMethod() > 0 {
+			return invalid()
+		}
+		v.Set(reflect.ValueOf(s))
+
+	case reflect.Bool:
+		parsed, err := strconv.ParseBool(s)
+		if err != nil {
+			return invalid()
+		}
+		v.SetBool(parsed)
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Int64:
+		parsed, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return invalid()
+		}
+		v.SetInt(parsed)
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64, reflect.Uintptr:
+		parsed, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return invalid()
+		}
+		v.SetUint(parsed)
+
+	case reflect.Float32, reflect.Float64:
+		parsed, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return invalid()
+		}
+		v.SetFloat(parsed)
+
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.Uint8 {
+			return invalid()
+		}
+		v.SetBytes([]byte(s))
+
+	default:
+		return invalid()
+	}
+
+	return nil
+}
+
+// isTextType reports whether values of type t are parsed from a
+// single string, even though t is a struct or a slice.
+func isTextType(t reflect.Type) bool {
+	if reflect.PtrTo(t).Implements(textUnmarshalerType) {
+		return true
+	}
+
+	return t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8
+}
+
+// toFloat converts a numeric value to a float64.
+func toFloat(rv reflect.Value) (float64, bool) {
+	switch rv.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64, reflect.Uintptr:
+		return float64(rv.Uint()), true
+	}
+
+	return 0, false
+}
+
+// typeName returns the name used for the type t in a FieldError.
+func typeName(t reflect.Type) string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == timeType:
+		return "RFC 3339 time"
+	case t == durationType:
+		return "duration"
+	}
+
+	switch t.Kind() {
+	case reflect.Struct, reflect.Map:
+		return "object"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	}
+
+	return t.Kind().String()
+}
+
+// dataTypeName returns the name used for the type of a value from the
+// Request Data in a FieldError.
+func dataTypeName(raw interface{}) string {
+	switch raw.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	}
+
+	if _, isNumber := toFloat(reflect.ValueOf(raw)); isNumber {
+		return "number"
+	}
+
+	return fmt.Sprintf("%T", raw)
+}
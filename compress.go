@@ -0,0 +1,449 @@
+package galago
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultCompressionMinSize is the minimum size of a response body in
+// bytes that is compressed when no minimum has been configured in the
+// CompressionOptions.
+const DefaultCompressionMinSize = 1024
+
+// incompressibleTypes are the media types of content that is already
+// compressed. Entries ending in `/` match every subtype.
+var incompressibleTypes = []string{
+	"image/",
+	"audio/",
+	"video/",
+	"font/woff",
+	"font/woff2",
+	"application/font-woff",
+	"application/gzip",
+	"application/x-gzip",
+	"application/zip",
+	"application/x-bzip2",
+	"application/x-xz",
+	"application/x-7z-compressed",
+	"application/x-rar-compressed",
+	"application/zstd",
+	"application/pdf",
+}
+
+// compressibleImages are image types that are not already compressed.
+var compressibleImages = []string{
+	"image/svg+xml",
+	"image/bmp",
+	"image/x-icon",
+	"image/vnd.microsoft.icon",
+}
+
+// CompressionOptions configures the Middleware created by
+// NewCompressionMiddleware.
+type CompressionOptions struct {
+	// The compression level, from gzip.BestSpeed to
+	// gzip.BestCompression. Defaults to gzip.DefaultCompression.
+	Level int
+	// The minimum size of a response body in bytes for it to be
+	// compressed. Defaults to DefaultCompressionMinSize.
+	MinSize int
+	// Media types that are never compressed, in addition to types
+	// that are already compressed such as images and archives.
+	// Entries ending in `/`, such as `video/`, match every subtype.
+	ExcludeTypes []string
+}
+
+// compression holds the state of a compression Middleware.
+type compression struct {
+	options CompressionOptions
+	gzip    sync.Pool
+	zlib    sync.Pool
+}
+
+// NewCompressionMiddleware creates a Middleware that compresses
+// response bodies using gzip or deflate, whichever is preferred in the
+// Accept-Encoding header of the request, and adds Accept-Encoding to
+// the Vary header of every response.
+//
+// Bodies smaller than the MinSize of the options are sent as they
+// are, along with responses with a Content-Encoding, partial content
+// and content types that are already compressed. Streaming responses
+// are compressed as they are written, and each flush sends the data
+// compressed so far to the client. Responses to HEAD requests carry
+// the same Content-Encoding, Content-Length and ETag headers as the
+// response to a GET request would.
+//
+// Request bodies sent with `Content-Encoding: gzip` are decompressed
+// before they are deserialized. The MaxBodyBytes of the App and Route
+// limit the size of the decompressed body.
+func NewCompressionMiddleware(options CompressionOptions) Middleware {
+	if options.Level == 0 {
+		options.Level = gzip.DefaultCompression
+	}
+	if options.MinSize <= 0 {
+		options.MinSize = DefaultCompressionMinSize
+	}
+
+	c := &compression{options: options}
+
+	return Middleware{WrapHTTP: c.wrap}
+}
+
+// wrap returns an http.Handler that decompresses the request body and
+// compresses the response body before passing them to next.
+func (c *compression) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// A connection that is upgraded to another protocol, such as
+		// a WebSocket, must not be wrapped.
+		if headerHasToken(r.Header, "Connection", "upgrade") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		decompress(r)
+
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{
+			ResponseWriter: w,
+			compression:    c,
+			encoding:       encoding,
+			head:           r.Method == http.MethodHead,
+			status:         http.StatusOK,
+		}
+		defer cw.close()
+
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// decompress replaces the body of a request sent with
+// `Content-Encoding: gzip` with a reader decompressing it. If the body
+// is not valid gzip, reading it fails and the client receives a 400
+// Bad Request without the details of the failure.
+func decompress(r *http.Request) {
+	encoding := strings.ToLower(
+		strings.TrimSpace(r.Header.Get("Content-Encoding")))
+	if encoding != "gzip" && encoding != "x-gzip" {
+		return
+	}
+	if r.Body == nil || r.Body == http.NoBody {
+		return
+	}
+
+	r.Body = &gzipBody{body: r.Body}
+	r.Header.Del("Content-Encoding")
+	r.Header.Del("Content-Length")
+	r.ContentLength = -1
+}
+
+// gzipBody decompresses a request body as it is read.
+type gzipBody struct {
+	body   io.ReadCloser
+	reader *gzip.Reader
+	err    error
+}
+
+// Read reads decompressed data from the request body.
+func (gb *gzipBody) Read(data []byte) (int, error) {
+	if gb.reader == nil && gb.err == nil {
+		gb.reader, gb.err = gzip.NewReader(gb.body)
+	}
+	if gb.err != nil {
+		return 0, gb.err
+	}
+
+	return gb.reader.Read(data)
+}
+
+// Close closes the request body.
+func (gb *gzipBody) Close() error {
+	return gb.body.Close()
+}
+
+// encoder returns a pooled writer compressing to w using the specified
+// encoding.
+func (c *compression) encoder(
+	encoding string, w io.Writer,
+) io.WriteCloser {
+	if encoding == "gzip" {
+		if gw, isGzip := c.gzip.Get().(*gzip.Writer); isGzip {
+			gw.Reset(w)
+			return gw
+		}
+		gw, err := gzip.NewWriterLevel(w, c.options.Level)
+		if err != nil {
+			gw = gzip.NewWriter(w)
+		}
+		return gw
+	}
+
+	if zw, isZlib := c.zlib.Get().(*zlib.Writer); isZlib {
+		zw.Reset(w)
+		return zw
+	}
+	zw, err := zlib.NewWriterLevel(w, c.options.Level)
+	if err != nil {
+		zw = zlib.NewWriter(w)
+	}
+	return zw
+}
+
+// release returns the writer to its pool.
+func (c *compression) release(enc io.WriteCloser) {
+	switch enc := enc.(type) {
+	case *gzip.Writer:
+		c.gzip.Put(enc)
+	case *zlib.Writer:
+		c.zlib.Put(enc)
+	}
+}
+
+// compressible reports whether a response with the specified headers
+// and status can be compressed.
+func (c *compression) compressible(h http.Header, status int) bool {
+	switch {
+	case status == http.StatusNoContent,
+		status == http.StatusPartialContent,
+		status == http.StatusNotModified:
+		return false
+	case h.Get("Content-Encoding") != "", h.Get("Content-Range") != "":
+		return false
+	}
+
+	mediaType := baseMediaType(h.Get("Content-Type"))
+	if matchesType(mediaType, c.options.ExcludeTypes) {
+		return false
+	}
+
+	return matchesType(mediaType, compressibleImages) ||
+		!matchesType(mediaType, incompressibleTypes)
+}
+
+// matchesType reports whether the media type is in the list of types.
+// Entries ending in `/` match every subtype.
+func matchesType(mediaType string, types []string) bool {
+	for _, t := range types {
+		t = strings.ToLower(t)
+		if mediaType == t ||
+			strings.HasSuffix(t, "/") && strings.HasPrefix(mediaType, t) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// compressWriter compresses the body of a response once it is known
+// to be large enough, buffering the start of the body until then.
+type compressWriter struct {
+	http.ResponseWriter
+	compression *compression
+	encoding    string
+	// Whether or not the response is to a HEAD request, in which case
+	// the headers are set as they would be for a GET request but no
+	// body is compressed.
+	head        bool
+	status      int
+	wroteHeader bool
+	decided     bool
+	enc         io.WriteCloser
+	buf         []byte
+}
+
+// WriteHeader records the status of the response. Unless the response
+// can not be compressed, the status is only written once the start of
+// the body has been written.
+func (cw *compressWriter) WriteHeader(status int) {
+	// Informational responses are sent before the final status.
+	if status >= 100 && status < http.StatusOK {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+	cw.status = status
+
+	h := cw.Header()
+	if !cw.compression.compressible(h, status) {
+		cw.pass()
+		return
+	}
+
+	if length := h.Get("Content-Length"); length != "" {
+		n, err := strconv.Atoi(length)
+		if err == nil && n < cw.compression.options.MinSize {
+			cw.pass()
+		} else {
+			cw.start()
+		}
+	} else if cw.head {
+		// The body of a response to a HEAD request is never written,
+		// so a response of unknown length, such as a stream, is sent
+		// with the headers it would be compressed with.
+		cw.start()
+	}
+}
+
+// Write writes the data to the client, compressing it if the body is
+// large enough.
+func (cw *compressWriter) Write(data []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+
+	if cw.decided {
+		if cw.enc != nil {
+			return cw.enc.Write(data)
+		}
+		return cw.ResponseWriter.Write(data)
+	}
+
+	cw.buf = append(cw.buf, data...)
+	if len(cw.buf) >= cw.compression.options.MinSize {
+		cw.start()
+		if err := cw.flushBuffer(); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(data), nil
+}
+
+// Flush sends the data written so far to the client. A response that
+// is flushed before its size is known is compressed, since it is
+// being streamed.
+func (cw *compressWriter) Flush() {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+
+	if !cw.decided {
+		cw.start()
+		cw.flushBuffer()
+	}
+
+	if flusher, isFlusher := cw.enc.(interface{ Flush() error }); isFlusher {
+		flusher.Flush()
+	}
+	if flusher, isFlusher := cw.ResponseWriter.(http.Flusher); isFlusher {
+		flusher.Flush()
+	}
+}
+
+// pass writes the status and sends the body without compressing it.
+func (cw *compressWriter) pass() {
+	cw.decided = true
+	cw.ResponseWriter.WriteHeader(cw.status)
+}
+
+// start sets the headers of a compressed response, writes the status
+// and starts compressing the body.
+func (cw *compressWriter) start() {
+	cw.decided = true
+
+	h := cw.Header()
+	h.Set("Content-Encoding", cw.encoding)
+	h.Del("Content-Length")
+	h.Del("Accept-Ranges")
+	if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		h.Set("ETag", "W/"+etag)
+	}
+
+	cw.ResponseWriter.WriteHeader(cw.status)
+	if !cw.head {
+		cw.enc = cw.compression.encoder(cw.encoding, cw.ResponseWriter)
+	}
+}
+
+// flushBuffer writes the buffered start of the body.
+func (cw *compressWriter) flushBuffer() error {
+	buf := cw.buf
+	cw.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+
+	var err error
+	if cw.enc != nil {
+		_, err = cw.enc.Write(buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(buf)
+	}
+
+	return err
+}
+
+// close sends a body that was too small to be compressed, or finishes
+// compressing the body.
+func (cw *compressWriter) close() {
+	if !cw.decided {
+		if !cw.wroteHeader && len(cw.buf) == 0 {
+			return
+		}
+		cw.pass()
+		cw.flushBuffer()
+		return
+	}
+
+	if cw.enc != nil {
+		cw.enc.Close()
+		cw.compression.release(cw.enc)
+		cw.enc = nil
+	}
+}
+
+// negotiateEncoding returns the encoding to compress a response with
+// based on the Accept-Encoding header of the request, preferring gzip
+// over deflate. If neither is acceptable, an empty string is
+// returned.
+func negotiateEncoding(acceptEncoding string) string {
+	qualities := map[string]float64{}
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		params := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(params[0]))
+		if coding == "" {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range params[1:] {
+			key, val, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.EqualFold(key, "q") {
+				parsed, err := strconv.ParseFloat(val, 64)
+				if err != nil {
+					parsed = 0
+				}
+				q = parsed
+			}
+		}
+		qualities[coding] = q
+	}
+
+	best, bestQ := "", 0.0
+	for _, coding := range []string{"gzip", "deflate"} {
+		q, exists := qualities[coding]
+		if !exists {
+			q, exists = qualities["*"]
+		}
+		if exists && q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+
+	return best
+}
@@ -0,0 +1,254 @@
+package galago
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// compressionApp returns an App using a compression Middleware with a
+// MinSize of 100 bytes, along with a body larger than the MinSize.
+func compressionApp() (*App, string) {
+	large := strings.Repeat("abcdefghij", 20)
+	text := func(status int, body string, contentType string) RouteHandler {
+		return func(Request) *Response {
+			return NewResponse(status, body).
+				SetSerializer(NewRawSerializer("data", contentType))
+		}
+	}
+
+	app := &App{}
+	app.AddMiddleware(NewCompressionMiddleware(CompressionOptions{
+		MinSize:      100,
+		ExcludeTypes: []string{"text/csv"},
+	}))
+	app.AddController(NewController().
+		AddRoute(NewRoute(http.MethodGet, "small",
+			text(http.StatusOK, "small", "text/plain"))).
+		AddRoute(NewRoute(http.MethodGet, "large",
+			text(http.StatusOK, large, "text/plain"))).
+		AddRoute(NewRoute(http.MethodGet, "image",
+			text(http.StatusOK, large, "image/png"))).
+		AddRoute(NewRoute(http.MethodGet, "csv",
+			text(http.StatusOK, large, "text/csv"))).
+		AddRoute(NewRoute(http.MethodGet, "svg",
+			text(http.StatusOK, large, "image/svg+xml"))).
+		AddRoute(NewRoute(http.MethodGet, "stream",
+			func(Request) *Response {
+				return NewStreamResponse(http.StatusOK,
+					func(stream *Stream) error {
+						io.WriteString(stream, "first")
+						stream.Flush()
+						io.WriteString(stream, "second")
+						return nil
+					}).SetHeader("Content-Type", "text/plain")
+			})).
+		AddRoute(NewStaticRoute("files", fstest.MapFS{
+			"large.txt": &fstest.MapFile{
+				Data:    []byte(large),
+				ModTime: time.Unix(1000000000, 0),
+			},
+		})))
+
+	return app, large
+}
+
+// decompressedBody returns the body of the response, decompressing it
+// according to its Content-Encoding.
+func decompressedBody(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+
+	var reader io.Reader = w.Body
+	var err error
+	switch w.Header().Get("Content-Encoding") {
+	case "gzip":
+		reader, err = gzip.NewReader(w.Body)
+	case "deflate":
+		reader, err = zlib.NewReader(w.Body)
+	}
+	if err != nil {
+		t.Fatalf("failed to decompress the body: %v", err)
+	}
+
+	body, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("failed to decompress the body: %v", err)
+	}
+
+	return string(body)
+}
+
+func TestCompressionResponses(t *testing.T) {
+	app, large := compressionApp()
+
+	tests := []struct {
+		name     string
+		path     string
+		accept   string
+		headers  map[string]string
+		status   int
+		encoding string
+		body     string
+	}{
+		{"below min size", "/small", "gzip", nil,
+			http.StatusOK, "", "small"},
+		{"above min size", "/large", "gzip", nil,
+			http.StatusOK, "gzip", large},
+		{"deflate", "/large", "deflate", nil,
+			http.StatusOK, "deflate", large},
+		{"preferred encoding", "/large", "gzip;q=0.5, deflate", nil,
+			http.StatusOK, "deflate", large},
+		{"wildcard", "/large", "gzip;q=0, *", nil,
+			http.StatusOK, "deflate", large},
+		{"not accepted", "/large", "", nil,
+			http.StatusOK, "", large},
+		{"identity", "/large", "identity", nil,
+			http.StatusOK, "", large},
+		{"flush before min size", "/stream", "gzip", nil,
+			http.StatusOK, "gzip", "firstsecond"},
+		{"already compressed type", "/image", "gzip", nil,
+			http.StatusOK, "", large},
+		{"compressible image", "/svg", "gzip", nil,
+			http.StatusOK, "gzip", large},
+		{"excluded type", "/csv", "gzip", nil,
+			http.StatusOK, "", large},
+		{"file", "/files/large.txt", "gzip", nil,
+			http.StatusOK, "gzip", large},
+		{"partial content", "/files/large.txt", "gzip",
+			map[string]string{"Range": "bytes=0-9"},
+			http.StatusPartialContent, "", large[:10]},
+		{"not modified", "/files/large.txt", "gzip",
+			map[string]string{
+				"If-Modified-Since": time.Unix(1000000000, 0).UTC().
+					Format(http.TimeFormat),
+			},
+			http.StatusNotModified, "", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, test.path, nil)
+			if test.accept != "" {
+				r.Header.Set("Accept-Encoding", test.accept)
+			}
+			for name, value := range test.headers {
+				r.Header.Set(name, value)
+			}
+			w := httptest.NewRecorder()
+			app.ServeHTTP(w, r)
+
+			if w.Code != test.status {
+				t.Errorf("expected status %v, got %v", test.status, w.Code)
+			}
+			encoding := w.Header().Get("Content-Encoding")
+			if encoding != test.encoding {
+				t.Errorf("expected Content-Encoding %q, got %q",
+					test.encoding, encoding)
+			}
+			if vary := w.Header().Get("Vary"); vary != "Accept-Encoding" {
+				t.Errorf("expected Vary Accept-Encoding, got %q", vary)
+			}
+			if body := decompressedBody(t, w); body != test.body {
+				t.Errorf("expected %q, got %q", test.body, body)
+			}
+		})
+	}
+}
+
+func TestCompressionHead(t *testing.T) {
+	app, _ := compressionApp()
+
+	for _, path := range []string{"/large", "/files/large.txt"} {
+		t.Run(path, func(t *testing.T) {
+			responses := map[string]*httptest.ResponseRecorder{}
+			for _, method := range []string{http.MethodGet, http.MethodHead} {
+				r := httptest.NewRequest(method, path, nil)
+				r.Header.Set("Accept-Encoding", "gzip")
+				w := httptest.NewRecorder()
+				app.ServeHTTP(w, r)
+				responses[method] = w
+			}
+
+			get, head := responses[http.MethodGet], responses[http.MethodHead]
+			if head.Body.Len() != 0 {
+				t.Errorf("expected no body, got %q", head.Body)
+			}
+			headers := []string{
+				"Content-Encoding", "Content-Length", "Accept-Ranges", "ETag",
+			}
+			for _, name := range headers {
+				if get.Header().Get(name) != head.Header().Get(name) {
+					t.Errorf("expected %v %q, got %q", name,
+						get.Header().Get(name), head.Header().Get(name))
+				}
+			}
+		})
+	}
+}
+
+func TestCompressionRequestBody(t *testing.T) {
+	echo := func(request Request) *Response {
+		return NewResponse(http.StatusOK, request.Data)
+	}
+	text := NewRoute(http.MethodPost, "text", echo)
+	text.Serializer = TextSerializer()
+
+	app := &App{}
+	app.AddMiddleware(NewCompressionMiddleware(CompressionOptions{}))
+	app.AddController(NewController().
+		AddRoute(NewRoute(http.MethodPost, "json", echo)).
+		AddRoute(text))
+
+	var compressed bytes.Buffer
+	gw := gzip.NewWriter(&compressed)
+	io.WriteString(gw, `{"name":"value"}`)
+	gw.Close()
+
+	tests := []struct {
+		name   string
+		path   string
+		body   []byte
+		status int
+		output string
+	}{
+		{"json", "/json", compressed.Bytes(),
+			http.StatusOK, `{"name":"value"}`},
+		{"text", "/text", compressed.Bytes(),
+			http.StatusOK, `{"name":"value"}`},
+		{"invalid json", "/json", []byte("not gzip"),
+			http.StatusBadRequest, ""},
+		{"invalid text", "/text", []byte("not gzip"),
+			http.StatusBadRequest, ""},
+		{"truncated text", "/text", compressed.Bytes()[:10],
+			http.StatusBadRequest, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(
+				http.MethodPost, test.path, bytes.NewReader(test.body))
+			r.Header.Set("Content-Encoding", "gzip")
+			w := httptest.NewRecorder()
+			app.ServeHTTP(w, r)
+
+			if w.Code != test.status {
+				t.Errorf("expected status %v, got %v: %v",
+					test.status, w.Code, w.Body)
+			}
+			body := strings.TrimSpace(w.Body.String())
+			if test.output != "" && body != test.output {
+				t.Errorf("expected %q, got %q", test.output, body)
+			}
+			if strings.Contains(body, "gzip") || strings.Contains(body, "EOF") {
+				t.Errorf("expected the decoder error to be hidden, got %q", body)
+			}
+		})
+	}
+}
@@ -15,8 +15,14 @@ func NewController() *Controller {
 	}
 }
 
-// AddRoute adds a new Route to the Controller.
+// AddRoute adds a new Route to the Controller. If the path of the
+// Route is invalid, the error is logged and the App the Controller is
+// added to will fail to start. See Route.Err.
 func (controller *Controller) AddRoute(route *Route) *Controller {
+	if err := route.Err(); err != nil && logger != nil {
+		logger.Printf("error : %v\n", err)
+	}
+
 	controller.routes = append(controller.routes, route)
 	return controller
 }
@@ -69,19 +75,22 @@ func getAllRoutes(controllers ...*Controller) RouteCollection {
 	return res
 }
 
-// getRoutes retrieves all Routes within this Controller and applies
-// the Middleware to the designated Routes.
+// getRoutes retrieves all Routes within this Controller and resolves
+// the effective Middleware chain for each of them. Resolving a Route
+// replaces any Middleware it previously inherited, so this is safe to
+// call more than once.
 func (controller *Controller) getRoutes() RouteCollection {
 	res := RouteCollection{}
 
 	for _, route := range controller.routes {
+		inherited := []Middleware{}
+		inherited = append(inherited, controller.middleware["*"]...)
 		for path, mwc := range controller.middleware {
-			if path == "*" || route.Path == path {
-				for _, mw := range mwc {
-					route.AddMiddleware(mw)
-				}
+			if path != "*" && route.Path == path {
+				inherited = append(inherited, mwc...)
 			}
 		}
+		route.resolve(inherited)
 		res = append(res, route)
 	}
 
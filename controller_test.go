@@ -0,0 +1,49 @@
+package galago
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestControllerMiddlewareRunsOncePerRequest(t *testing.T) {
+	const requests = 5000
+
+	var hooks, routeHooks int
+	controller := NewController().
+		AddRoute(NewRoute(http.MethodGet, "ping", func(Request) *Response {
+			return NewResponse(http.StatusOK, nil)
+		})).
+		AddMiddleware(Middleware{After: func(*Response) { hooks++ }}).
+		AddMiddlewareFor([]string{"ping"}, Middleware{
+			After: func(*Response) { routeHooks++ },
+		})
+
+	app := &App{}
+	app.AddController(controller)
+
+	for i := 0; i < requests; i++ {
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ping", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("request %d: expected status 200, got %v", i, w.Code)
+		}
+	}
+
+	if hooks != requests {
+		t.Errorf("expected %v After hooks, got %v", requests, hooks)
+	}
+	if routeHooks != requests {
+		t.Errorf("expected %v route After hooks, got %v",
+			requests, routeHooks)
+	}
+
+	// Resolving the Routes again must not duplicate the inherited
+	// Middleware.
+	app.getRoutes()
+	route := controller.routes[0]
+	if len(route.Middleware) != 0 || len(route.middleware()) != 2 {
+		t.Errorf("expected 0 own and 2 effective Middleware, got %v and %v",
+			len(route.Middleware), len(route.middleware()))
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"log"
 	"net/http"
 
 	galago ".."
@@ -23,7 +24,12 @@ func main() {
 
 	// You can add multiple controllers to your app
 	app.AddController(DemoController())
-	app.Listen()
+
+	// Listen blocks until the process receives SIGINT or SIGTERM and
+	// the App has finished shutting down.
+	if err := app.Listen(); err != nil {
+		log.Fatal(err)
+	}
 }
 
 // DemoController returns the Controller used for this Demo.
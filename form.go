@@ -0,0 +1,154 @@
+package galago
+
+import (
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"sort"
+)
+
+// DefaultMaxMultipartMemory is the maximum number of bytes of a
+// multipart form that are held in memory when no maximum has been
+// configured on the App.
+const DefaultMaxMultipartMemory = 32 << 20
+
+// FormFile is a file uploaded to the App in a multipart form.
+type FormFile struct {
+	// The name of the form field the file was uploaded with.
+	Field string
+	// The name of the file provided by the client.
+	Name string
+	// The size of the file in bytes.
+	Size int64
+	// The content type of the file provided by the client.
+	ContentType string
+	header      *multipart.FileHeader
+}
+
+// Open opens the file for reading. The caller should close the file
+// once it has been read.
+func (file *FormFile) Open() (multipart.File, error) {
+	return file.header.Open()
+}
+
+// File returns the first file uploaded with the specified form field.
+// If none exists, nil is returned.
+func (request *Request) File(field string) *FormFile {
+	for _, file := range request.files {
+		if file.Field == field {
+			return file
+		}
+	}
+
+	return nil
+}
+
+// Files returns every file uploaded with the Request, ordered by the
+// name of the form field they were uploaded with.
+func (request *Request) Files() []*FormFile {
+	return request.files
+}
+
+// isForm reports whether the request body holds an HTML form.
+func isForm(r *http.Request) bool {
+	switch baseMediaType(r.Header.Get("Content-Type")) {
+	case "application/x-www-form-urlencoded", "multipart/form-data":
+		return true
+	}
+
+	return false
+}
+
+// parseForm parses the HTML form in the request body into the data
+// for the Request and the files that were uploaded with it. Files in a
+// multipart form that do not fit within the MaxMultipartMemory of the
+// App are stored in temporary files that are removed by cleanupForm.
+func (app *App) parseForm(r *http.Request) (
+	map[string]interface{}, []*FormFile, error) {
+	if baseMediaType(r.Header.Get("Content-Type")) != "multipart/form-data" {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		values, err := url.ParseQuery(string(body))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return formData(values), nil, nil
+	}
+
+	maxMemory := app.MaxMultipartMemory
+	if maxMemory <= 0 {
+		maxMemory = DefaultMaxMultipartMemory
+	}
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	form, err := reader.ReadForm(maxMemory)
+	if err != nil {
+		return nil, nil, err
+	}
+	r.MultipartForm = form
+
+	fields := make([]string, 0, len(form.File))
+	for field := range form.File {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	files := []*FormFile{}
+	for _, field := range fields {
+		for _, header := range form.File[field] {
+			files = append(files, &FormFile{
+				Field:       field,
+				Name:        header.Filename,
+				Size:        header.Size,
+				ContentType: header.Header.Get("Content-Type"),
+				header:      header,
+			})
+		}
+	}
+
+	return formData(form.Value), files, nil
+}
+
+// cleanupForm removes any temporary files created while parsing a
+// multipart form in the request body.
+func cleanupForm(r *http.Request) {
+	if r.MultipartForm == nil {
+		return
+	}
+
+	if err := r.MultipartForm.RemoveAll(); err != nil {
+		if logger != nil {
+			logger.Printf("error : failed to remove form files: %v\n", err)
+		}
+	}
+}
+
+// formData converts the values of a form into the data for a Request.
+// Keys with a single value are mapped to a string, and keys that are
+// repeated are mapped to an array of strings.
+func formData(values map[string][]string) map[string]interface{} {
+	res := make(map[string]interface{}, len(values))
+	for key, vals := range values {
+		if len(vals) == 1 {
+			res[key] = vals[0]
+			continue
+		}
+
+		items := make([]interface{}, len(vals))
+		for i, val := range vals {
+			items[i] = val
+		}
+		res[key] = items
+	}
+
+	return res
+}
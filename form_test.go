@@ -0,0 +1,129 @@
+package galago
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestURLEncodedForm(t *testing.T) {
+	var data map[string]interface{}
+
+	app := &App{}
+	app.AddController(NewController().AddRoute(
+		NewRoute(http.MethodPost, "form", func(request Request) *Response {
+			data = request.Data
+			return NewResponse(http.StatusOK, nil)
+		})))
+
+	r := httptest.NewRequest(http.MethodPost, "/form",
+		strings.NewReader("name=galago&tag=a&tag=b&tag=c&empty="))
+	r.Header.Set("Content-Type",
+		"application/x-www-form-urlencoded; charset=utf-8")
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %v: %v", w.Code, w.Body.String())
+	}
+
+	expected := map[string]interface{}{
+		"name":  "galago",
+		"tag":   []interface{}{"a", "b", "c"},
+		"empty": "",
+	}
+	if !reflect.DeepEqual(data, expected) {
+		t.Errorf("expected %v, got %v", expected, data)
+	}
+}
+
+func TestMultipartForm(t *testing.T) {
+	var b bytes.Buffer
+	mw := multipart.NewWriter(&b)
+	mw.WriteField("name", "galago")
+	mw.WriteField("tag", "a")
+	mw.WriteField("tag", "b")
+	for _, file := range []struct{ field, name, data string }{
+		{"upload", "first.txt", "first file"},
+		{"attachment", "second.txt", strings.Repeat("x", 1024)},
+	} {
+		fw, err := mw.CreateFormFile(file.field, file.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(fw, file.data)
+	}
+	mw.Close()
+
+	var (
+		data        map[string]interface{}
+		files       []*FormFile
+		terminated  string
+		terminateOK bool
+	)
+
+	app := &App{MaxMultipartMemory: 1}
+	app.AddMiddleware(Middleware{
+		Terminate: func(request *Request, _ *Response) {
+			// Files remain available until the Terminate Middleware
+			// has run.
+			f, err := request.File("upload").Open()
+			if err != nil {
+				return
+			}
+			defer f.Close()
+			contents, err := io.ReadAll(f)
+			terminated, terminateOK = string(contents), err == nil
+		},
+	})
+	app.AddController(NewController().AddRoute(
+		NewRoute(http.MethodPost, "form", func(request Request) *Response {
+			data, files = request.Data, request.Files()
+			return NewResponse(http.StatusOK, nil)
+		})))
+
+	r := httptest.NewRequest(http.MethodPost, "/form", &b)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %v: %v", w.Code, w.Body.String())
+	}
+
+	expected := map[string]interface{}{
+		"name": "galago",
+		"tag":  []interface{}{"a", "b"},
+	}
+	if !reflect.DeepEqual(data, expected) {
+		t.Errorf("expected %v, got %v", expected, data)
+	}
+
+	if len(files) != 2 {
+		t.Fatalf("expected 2 files, got %v", len(files))
+	}
+	if files[0].Field != "attachment" || files[0].Name != "second.txt" ||
+		files[0].Size != 1024 {
+		t.Errorf("unexpected first file %+v", files[0])
+	}
+	if files[1].Field != "upload" || files[1].Name != "first.txt" {
+		t.Errorf("unexpected second file %+v", files[1])
+	}
+
+	if !terminateOK || terminated != "first file" {
+		t.Errorf("expected the file to be readable while terminating, "+
+			"got %q", terminated)
+	}
+	for _, file := range files {
+		if f, err := file.Open(); err == nil {
+			f.Close()
+			t.Errorf("expected %v to be removed once the request was "+
+				"handled", file.Name)
+		}
+	}
+}
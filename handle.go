@@ -0,0 +1,162 @@
+package galago
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+)
+
+// HTTPError is an error that is sent to the client with a specific
+// HTTP Status Code when it is returned from a Handle function.
+type HTTPError struct {
+	// The HTTP Status Code for the Response.
+	Status int
+	// The message sent to the client. If empty, the text for the
+	// Status is used.
+	Message string
+	// The underlying error, if any. This is not sent to the client.
+	Err error
+}
+
+// NewHTTPError creates a new HTTPError with the specified HTTP Status
+// Code and message.
+func NewHTTPError(status int, message string) *HTTPError {
+	return &HTTPError{Status: status, Message: message}
+}
+
+// Error returns the message for the HTTPError, along with the
+// underlying error if there is one.
+func (err *HTTPError) Error() string {
+	message := err.Message
+	if message == "" {
+		message = http.StatusText(err.Status)
+	}
+	if err.Err != nil {
+		return fmt.Sprintf("%v: %v", message, err.Err)
+	}
+
+	return message
+}
+
+// Unwrap returns the underlying error.
+func (err *HTTPError) Unwrap() error {
+	return err.Err
+}
+
+// ErrorMapper converts an error returned from a Handle function into
+// the Response that is sent to the client.
+type ErrorMapper func(err error) *Response
+
+// DefaultErrorMapper is the ErrorMapper used when none has been
+// configured on the App.
+//
+// A *BindError or *ValidationError is converted using its Response()
+// function, and an *HTTPError is sent with its Status and Message.
+// Any other error is logged and a 500 Internal Server Error is sent
+// without revealing the error to the client.
+func DefaultErrorMapper(err error) *Response {
+	var bindErr *BindError
+	var validationErr *ValidationError
+	var httpErr *HTTPError
+
+	switch {
+	case errors.As(err, &bindErr):
+		return bindErr.Response()
+	case errors.As(err, &validationErr):
+		return validationErr.Response()
+	case errors.As(err, &httpErr):
+		message := httpErr.Message
+		if message == "" {
+			message = http.StatusText(httpErr.Status)
+		}
+		return NewResponse(httpErr.Status, map[string]interface{}{
+			"error": message,
+		})
+	}
+
+	if logger != nil {
+		logger.Printf("error : %v\n", err)
+	}
+
+	return NewResponse(
+		http.StatusInternalServerError,
+		map[string]interface{}{
+			"error": "internal server error",
+		},
+	)
+}
+
+// mapError converts the specified error into a Response using the
+// ErrorMapper of the App, falling back to the DefaultErrorMapper.
+func (app *App) mapError(err error) *Response {
+	if app != nil && app.ErrorMapper != nil {
+		if response := app.ErrorMapper(err); response != nil {
+			return response
+		}
+	}
+
+	return DefaultErrorMapper(err)
+}
+
+// Handle creates a new Route with the specified HTTP method and path
+// that calls fn with a value of type In bound from the Request, and
+// responds with the value of type Out that it returns.
+//
+// In must be a struct, or a pointer to a struct, and is filled using
+// request.Bind(dst), so the struct tags described there and the rules
+// in its `validate` tags apply. Use struct{} for a Route that takes no
+// input. The context passed to fn is the context of the underlying
+// http.Request.
+//
+// Out is sent with a 200 OK status using the Serializer for the Route.
+// If Out is a *Response, it is sent as is. If fn returns an error, or
+// the Request can not be bound, the error is converted to a Response
+// using the ErrorMapper of the App.
+//
+// Handle panics if In is not a struct or a pointer to a struct.
+func Handle[In, Out any](
+	method, path string, fn func(context.Context, In) (Out, error),
+) *Route {
+	inType := reflect.TypeOf((*In)(nil)).Elem()
+	isPtr := inType.Kind() == reflect.Ptr
+	if isPtr {
+		inType = inType.Elem()
+	}
+	if inType.Kind() != reflect.Struct {
+		panic(fmt.Sprintf(
+			"galago: Handle input must be a struct or a pointer to a "+
+				"struct, got %v", reflect.TypeOf((*In)(nil)).Elem()))
+	}
+
+	return NewRoute(method, path, func(request Request) *Response {
+		var in In
+		dst := interface{}(&in)
+		if isPtr {
+			value := reflect.New(inType)
+			reflect.ValueOf(&in).Elem().Set(value)
+			dst = value.Interface()
+		}
+
+		if err := request.Bind(dst); err != nil {
+			return request.app.mapError(err)
+		}
+
+		ctx := context.Background()
+		if request.HTTPRequest != nil {
+			ctx = request.HTTPRequest.Context()
+		}
+
+		out, err := fn(ctx, in)
+		if err != nil {
+			return request.app.mapError(err)
+		}
+
+		if response, isResponse := interface{}(out).(*Response); isResponse {
+			return response
+		}
+
+		return NewResponse(http.StatusOK, out)
+	})
+}
@@ -1,5 +1,9 @@
 package galago
 
+import (
+	"net/http"
+)
+
 // Middleware acts as a form of pre processing for Requests and
 // Responses. It's a useful tool for filtering requests.
 //
@@ -7,11 +11,87 @@ package galago
 // basis by directly applying it to the Route, or to either all routes
 // in a Controller or specific routes in a Controller.
 type Middleware struct {
-	// Before is called Before any Request is handled.
+	// Before is called Before any Request is handled. It is not
+	// called if a Middleware that runs before it stops the Request.
 	Before func(*Request)
-	// After is called after a Response has been generated.
+	// After is called after a Response has been generated, with the
+	// Response returned by the rest of the chain. It is not called if
+	// a Middleware that runs before it stops the Request.
 	After func(*Response)
 	// Terminate is called after the response has been sent. Request
 	// can be nil under certain conditions.
 	Terminate func(*Request, *Response)
+	// Wrap is called with the next Handler in the chain and should
+	// return a Handler that calls it. The returned Handler can stop
+	// the Request from being processed any further by returning a
+	// Response without calling next. Wrap is called once for each
+	// Request. The Handler it returns runs after Before and before
+	// After.
+	Wrap func(next Handler) Handler
+	// WrapHTTP is called with the http.Handler that reads the request
+	// body and sends the Response, and should return an http.Handler
+	// that calls it. Use this to replace the http.ResponseWriter or
+	// the http.Request, such as to compress the response body. WrapHTTP
+	// is called once for each request matching a Route, before the
+	// request body is read.
+	WrapHTTP func(next http.Handler) http.Handler
+}
+
+// Handler processes a Request and returns a Response. Handlers are
+// chained together by Middleware, ending with the RouteHandler of
+// the Route matching the Request.
+type Handler func(*Request) *Response
+
+// wrapMiddleware wraps the specified Handler with a list of
+// Middleware, with the first being the outermost.
+//
+// Each Middleware is converted into a single wrapping function using
+// adapt, so a Middleware that stops a Request by returning a Response
+// without calling next also prevents the Before and After functions
+// of every Middleware after it from running.
+func wrapMiddleware(mws []Middleware, next Handler) Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		next = mws[i].adapt(next)
+	}
+
+	return next
+}
+
+// adapt wraps the specified Handler with this Middleware. The Request
+// is passed to Before, then through Wrap to next, and the Response
+// that comes back is passed to After.
+func (mw Middleware) adapt(next Handler) Handler {
+	if mw.Wrap != nil {
+		next = mw.Wrap(next)
+	}
+	if mw.Before == nil && mw.After == nil {
+		return next
+	}
+
+	return func(request *Request) *Response {
+		if mw.Before != nil {
+			mw.Before(request)
+		}
+
+		response := next(request)
+
+		if mw.After != nil {
+			mw.After(response)
+		}
+
+		return response
+	}
+}
+
+// wrapHTTP wraps the specified http.Handler with the WrapHTTP
+// functions from a list of Middleware, with the first being the
+// outermost.
+func wrapHTTP(mws []Middleware, next http.Handler) http.Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		if mws[i].WrapHTTP != nil {
+			next = mws[i].WrapHTTP(next)
+		}
+	}
+
+	return next
 }
@@ -0,0 +1,87 @@
+package galago
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// recordingMiddleware returns a Middleware appending the name of each
+// of its callbacks to calls as they run.
+func recordingMiddleware(name string, calls *[]string) Middleware {
+	return Middleware{
+		Before: func(*Request) { *calls = append(*calls, name+"-before") },
+		Wrap: func(next Handler) Handler {
+			return func(request *Request) *Response {
+				*calls = append(*calls, name+"-wrap")
+				return next(request)
+			}
+		},
+		After: func(*Response) { *calls = append(*calls, name+"-after") },
+	}
+}
+
+func TestMiddlewareOrder(t *testing.T) {
+	var calls []string
+
+	route := NewRoute(http.MethodGet, "items", func(Request) *Response {
+		calls = append(calls, "handler")
+		return NewResponse(http.StatusOK, nil)
+	})
+	route.AddMiddleware(recordingMiddleware("route", &calls))
+
+	controller := NewController().AddRoute(route)
+	controller.AddMiddleware(recordingMiddleware("controller", &calls))
+
+	app := &App{}
+	app.AddMiddleware(recordingMiddleware("app", &calls))
+	app.AddController(controller)
+
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/items", nil))
+
+	expected := []string{
+		"app-before", "app-wrap",
+		"route-before", "route-wrap",
+		"controller-before", "controller-wrap",
+		"handler",
+		"controller-after", "route-after", "app-after",
+	}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Errorf("expected %v, got %v", expected, calls)
+	}
+}
+
+func TestMiddlewareShortCircuit(t *testing.T) {
+	var calls []string
+
+	route := NewRoute(http.MethodGet, "items", func(Request) *Response {
+		calls = append(calls, "handler")
+		return NewResponse(http.StatusOK, nil)
+	})
+	route.AddMiddleware(Middleware{
+		Wrap: func(next Handler) Handler {
+			return func(*Request) *Response {
+				return NewResponse(http.StatusUnauthorized, nil)
+			}
+		},
+	})
+	route.AddMiddleware(recordingMiddleware("inner", &calls))
+
+	app := &App{}
+	app.AddMiddleware(recordingMiddleware("outer", &calls))
+	app.AddController(NewController().AddRoute(route))
+
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/items", nil))
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected status 401, got %v", w.Code)
+	}
+
+	expected := []string{"outer-before", "outer-wrap", "outer-after"}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Errorf("expected %v, got %v", expected, calls)
+	}
+}
@@ -0,0 +1,229 @@
+package galago
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// mediaSerializer is a Serializer registered with an App for a media
+// type.
+type mediaSerializer struct {
+	mediaType  string
+	serializer *Serializer
+}
+
+// RegisterSerializer registers a Serializer with the App for the
+// specified media type, such as "application/xml". Once a Serializer
+// has been registered, the App uses content negotiation to select the
+// Serializer for each request that is not handled by a Route with its
+// own Serializer.
+//
+// Input data is deserialized using the Serializer registered for the
+// Content-Type of the request, and a 415 Unsupported Media Type is sent
+// if there is none. Response data is serialized using the Serializer
+// that best matches the Accept header of the request, and a 406 Not
+// Acceptable is sent if none is acceptable. The Serializer of the App,
+// or the DefaultSerializer, is always available under its own content
+// type and is preferred when the client has no preference.
+//
+// Serializers should be registered before the App starts listening.
+func (app *App) RegisterSerializer(mediaType string, serializer *Serializer) {
+	mediaType = strings.ToLower(mediaType)
+	for i, ms := range app.serializers {
+		if ms.mediaType == mediaType {
+			app.serializers[i].serializer = serializer
+			return
+		}
+	}
+
+	app.serializers = append(
+		app.serializers, mediaSerializer{mediaType, serializer})
+}
+
+// RegisterXML registers an XML Serializer with the App under both
+// application/xml and text/xml, using the specified name for the root
+// element. If root is empty, DefaultXMLRoot is used. See
+// RegisterSerializer and NewXMLSerializer.
+func (app *App) RegisterXML(root string) {
+	if root == "" {
+		root = DefaultXMLRoot
+	}
+
+	serializer := NewXMLSerializer(root)
+	app.RegisterSerializer("application/xml", serializer)
+	app.RegisterSerializer("text/xml", serializer)
+}
+
+// fallbackSerializer returns the Serializer of the App, or the
+// DefaultSerializer if the App has none.
+func (app *App) fallbackSerializer() *Serializer {
+	if app.Serializer != nil {
+		return app.Serializer
+	}
+
+	return DefaultSerializer
+}
+
+// negotiable returns the Serializers available for content negotiation
+// in order of preference, starting with the Serializer of the App
+// unless another Serializer has been registered for its media type.
+func (app *App) negotiable() []mediaSerializer {
+	fallback := app.fallbackSerializer()
+	fallbackType := baseMediaType(fallback.ContentType)
+	for _, ms := range app.serializers {
+		if ms.mediaType == fallbackType {
+			return app.serializers
+		}
+	}
+
+	res := make([]mediaSerializer, 0, len(app.serializers)+1)
+	res = append(res, mediaSerializer{fallbackType, fallback})
+	return append(res, app.serializers...)
+}
+
+// inputSerializer selects the Serializer used to deserialize the input
+// data of the request. If content negotiation is in use and no
+// Serializer is registered for the Content-Type of the request, false
+// is returned.
+func (app *App) inputSerializer(
+	route *Route, r *http.Request,
+) (*Serializer, bool) {
+	if route.Serializer != nil {
+		return route.Serializer, true
+	}
+
+	contentType := r.Header.Get("Content-Type")
+	if len(app.serializers) == 0 || contentType == "" {
+		return app.fallbackSerializer(), true
+	}
+
+	mediaType := baseMediaType(contentType)
+	for _, ms := range app.negotiable() {
+		if ms.mediaType == mediaType {
+			return ms.serializer, true
+		}
+	}
+
+	return nil, false
+}
+
+// outputSerializer selects the Serializer used to serialize the
+// response data, along with the content type of the response. If
+// content negotiation is in use and no Serializer is acceptable to the
+// client, false is returned.
+func (app *App) outputSerializer(
+	route *Route, w http.ResponseWriter, r *http.Request,
+) (*Serializer, string, bool) {
+	if route.Serializer != nil {
+		return route.Serializer, route.Serializer.ContentType, true
+	}
+
+	fallback := app.fallbackSerializer()
+	if len(app.serializers) == 0 {
+		return fallback, fallback.ContentType, true
+	}
+
+	w.Header().Add("Vary", "Accept")
+
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return fallback, fallback.ContentType, true
+	}
+
+	ranges := parseAccept(accept)
+	var best *mediaSerializer
+	bestQ := 0.0
+	for _, ms := range app.negotiable() {
+		ms := ms
+		if q := acceptQuality(ranges, ms.mediaType); q > bestQ {
+			best, bestQ = &ms, q
+		}
+	}
+
+	if best == nil {
+		return nil, "", false
+	}
+
+	contentType := best.mediaType
+	if baseMediaType(best.serializer.ContentType) == best.mediaType {
+		contentType = best.serializer.ContentType
+	}
+
+	return best.serializer, contentType, true
+}
+
+// mediaRange is a single media range from an Accept header.
+type mediaRange struct {
+	mediaType string
+	q         float64
+}
+
+// parseAccept parses the media ranges in the specified Accept header.
+// Media ranges that can not be parsed are ignored.
+func parseAccept(accept string) []mediaRange {
+	res := []mediaRange{}
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+		if val, exists := params["q"]; exists {
+			if q, err = strconv.ParseFloat(val, 64); err != nil {
+				continue
+			}
+		}
+
+		res = append(res, mediaRange{mediaType, q})
+	}
+
+	return res
+}
+
+// acceptQuality returns the quality of the specified media type using
+// the most specific of the media ranges that match it, or 0 if none
+// do.
+func acceptQuality(ranges []mediaRange, mediaType string) float64 {
+	q, specificity := 0.0, -1
+	for _, mr := range ranges {
+		s := mediaRangeSpecificity(mr.mediaType, mediaType)
+		if s > specificity {
+			q, specificity = mr.q, s
+		}
+	}
+
+	return q
+}
+
+// mediaRangeSpecificity returns how specifically the media range
+// matches the media type. A wildcard matches with 0, a range with a
+// wildcard subtype matches with 1 and an exact match with 2. If the
+// range does not match, -1 is returned.
+func mediaRangeSpecificity(mediaRange, mediaType string) int {
+	switch {
+	case mediaRange == mediaType:
+		return 2
+	case mediaRange == "*/*":
+		return 0
+	case strings.HasSuffix(mediaRange, "/*") &&
+		strings.HasPrefix(mediaType, strings.TrimSuffix(mediaRange, "*")):
+		return 1
+	}
+
+	return -1
+}
+
+// baseMediaType returns the media type of the specified content type
+// without any parameters.
+func baseMediaType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return strings.ToLower(
+			strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	}
+
+	return mediaType
+}
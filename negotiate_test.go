@@ -0,0 +1,121 @@
+package galago
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAcceptQuality(t *testing.T) {
+	tests := []struct {
+		accept    string
+		mediaType string
+		expected  float64
+	}{
+		{"application/json", "application/json", 1},
+		{"application/json", "application/xml", 0},
+		{"application/json;q=0.5", "application/json", 0.5},
+		{"*/*", "application/xml", 1},
+		{"*/*;q=0.1", "application/xml", 0.1},
+		{"application/*;q=0.3", "application/xml", 0.3},
+		{"application/*;q=0.3", "text/xml", 0},
+		{"*/*;q=0.1, application/*;q=0.3", "application/xml", 0.3},
+		{"application/*;q=0.3, application/xml;q=0.7",
+			"application/xml", 0.7},
+		{"*/*, application/xml;q=0", "application/xml", 0},
+		{"application/xml;q=invalid, */*;q=0.2", "application/xml", 0.2},
+		{"", "application/xml", 0},
+	}
+
+	for _, test := range tests {
+		q := acceptQuality(parseAccept(test.accept), test.mediaType)
+		if q != test.expected {
+			t.Errorf("%q for %v: expected %v, got %v",
+				test.accept, test.mediaType, test.expected, q)
+		}
+	}
+}
+
+func TestContentNegotiation(t *testing.T) {
+	echo := func(request Request) *Response {
+		return NewResponse(http.StatusOK, request.Data)
+	}
+	text := NewRoute(http.MethodPost, "text", echo)
+	text.Serializer = TextSerializer()
+
+	app := &App{}
+	app.RegisterXML("")
+	app.AddController(NewController().
+		AddRoute(NewRoute(http.MethodPost, "items", echo)).
+		AddRoute(text))
+
+	tests := []struct {
+		name        string
+		path        string
+		contentType string
+		body        string
+		accept      string
+		status      int
+		output      string
+	}{
+		{"no preference", "/items", "application/json", `{"a":"b"}`,
+			"", http.StatusOK, "application/json"},
+		{"exact match", "/items", "application/json", `{"a":"b"}`,
+			"application/xml", http.StatusOK, "application/xml"},
+		{"registered alias", "/items", "application/json", `{"a":"b"}`,
+			"text/xml", http.StatusOK, "text/xml"},
+		{"highest quality", "/items", "application/json", `{"a":"b"}`,
+			"application/json;q=0.5, application/xml", http.StatusOK,
+			"application/xml"},
+		{"wildcard over lower quality", "/items", "application/json",
+			`{"a":"b"}`, "application/xml;q=0.5, */*", http.StatusOK,
+			"application/json"},
+		{"subtype wildcard", "/items", "application/json", `{"a":"b"}`,
+			"text/*", http.StatusOK, "text/xml"},
+		{"excluded type", "/items", "application/json", `{"a":"b"}`,
+			"application/json;q=0, */*;q=0.1", http.StatusOK,
+			"application/xml"},
+		{"not acceptable", "/items", "application/json", `{"a":"b"}`,
+			"text/css", http.StatusNotAcceptable, "application/json"},
+		{"xml input", "/items", "application/xml",
+			"<response><a>b</a></response>", "application/json",
+			http.StatusOK, "application/json"},
+		{"unsupported media type", "/items", "text/csv", "a,b",
+			"", http.StatusUnsupportedMediaType, "application/json"},
+		{"route serializer", "/text", "text/csv", "a,b",
+			"text/css", http.StatusOK, "text/plain"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(
+				http.MethodPost, test.path, strings.NewReader(test.body))
+			r.Header.Set("Content-Type", test.contentType)
+			if test.accept != "" {
+				r.Header.Set("Accept", test.accept)
+			}
+			w := httptest.NewRecorder()
+			app.ServeHTTP(w, r)
+
+			if w.Code != test.status {
+				t.Errorf("expected status %v, got %v: %v",
+					test.status, w.Code, w.Body.String())
+			}
+			contentType := baseMediaType(w.Header().Get("Content-Type"))
+			if contentType != test.output {
+				t.Errorf("expected Content-Type %v, got %v",
+					test.output, contentType)
+			}
+			if test.name == "xml input" && w.Body.String() != `{"a":"b"}` {
+				t.Errorf("expected the XML input echoed as JSON, got %q",
+					w.Body.String())
+			}
+			if test.path == "/items" && test.status == http.StatusOK {
+				if vary := w.Header().Get("Vary"); vary != "Accept" {
+					t.Errorf("expected Vary Accept, got %q", vary)
+				}
+			}
+		})
+	}
+}
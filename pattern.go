@@ -0,0 +1,328 @@
+package galago
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ParamTypes maps the names of the built in Route Parameter types to
+// the regular expression each one matches. A Route Parameter can be
+// constrained to one of these types using the `{name:type}` format,
+// for example `users/{id:int}`. Any constraint that is not found in
+// this map is treated as a regular expression, for example
+// `posts/{slug:[a-z-]+}`.
+var ParamTypes = map[string]string{
+	"int":   `-?[0-9]+`,
+	"alpha": `[a-zA-Z]+`,
+	"alnum": `[a-zA-Z0-9]+`,
+	"uuid": `[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-` +
+		`[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`,
+}
+
+var paramNameExpr = regexp.MustCompile(`^[a-zA-Z0-9_]+$`)
+
+// segment is a single path segment of a Route.
+type segment struct {
+	// The raw path segment as it was written in the Route path.
+	raw string
+	// Whether or not this segment was wrapped in square brackets.
+	optional bool
+	// Whether or not this segment is a catch-all parameter that
+	// matches the remainder of the path.
+	catchAll bool
+	// The name of the Route Parameter when a single parameter makes
+	// up the entire segment.
+	name string
+	// The names of all Route Parameters in this segment.
+	names []string
+	// The regular expression for this segment, with a named group
+	// for each Route Parameter.
+	expr string
+	// The anchored regex match for this segment. This is nil when
+	// the segment is static or accepts any non-empty value.
+	match *regexp.Regexp
+}
+
+// isStatic determines if this segment contains no Route Parameters.
+func (s *segment) isStatic() bool {
+	return len(s.names) == 0
+}
+
+// matches determines if the path segment specified in value matches
+// this segment.
+func (s *segment) matches(value string) bool {
+	if s.match == nil {
+		return value != ""
+	}
+
+	return s.match.MatchString(value)
+}
+
+// capture stores the Route Parameters found in the path segment
+// specified in value in fields.
+func (s *segment) capture(value string, fields map[string]string) {
+	if s.name != "" {
+		fields[s.name] = value
+		return
+	}
+
+	match := s.match.FindStringSubmatch(value)
+	for i, name := range s.match.SubexpNames() {
+		if name != "" && i < len(match) {
+			fields[name] = match[i]
+		}
+	}
+}
+
+// compilePath compiles the specified Route path into an anchored
+// regular expression matching the entire request path.
+func compilePath(path string) (*regexp.Regexp, error) {
+	segments, err := parsePath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	expr := "^"
+	for i, seg := range segments {
+		sep := ""
+		if i > 0 {
+			sep = "/"
+		}
+
+		if seg.optional {
+			expr += "(?:" + sep + seg.expr + ")?"
+		} else {
+			expr += sep + seg.expr
+		}
+	}
+
+	return regexp.Compile(expr + "$")
+}
+
+// parsePath splits a Route path into its segments. Optional segments
+// are specified by wrapping the segment, including its preceding
+// `/`, in square brackets. For example, `commit[/{id}]`. Optional
+// segments can follow one another, as in `users[/{id}][/{action}]`.
+func parsePath(path string) ([]*segment, error) {
+	path = strings.TrimPrefix(path, "/")
+
+	res := []*segment{}
+	if path == "" {
+		return res, nil
+	}
+
+	var current strings.Builder
+	optional := false
+	closed := false
+
+	flush := func() error {
+		seg, err := parseSegment(current.String(), optional)
+		if err != nil {
+			return err
+		}
+		res = append(res, seg)
+		current.Reset()
+		return nil
+	}
+
+	for i := 0; i < len(path); i++ {
+		// An optional segment can be followed by another optional
+		// segment, such as `users[/{id}][/{action}]`.
+		if closed {
+			closed = false
+			if path[i] == '/' {
+				continue
+			}
+			if path[i] != '[' {
+				return nil, fmt.Errorf(
+					"invalid route %q: optional segment must be "+
+						"followed by a '/' or a '['", path)
+			}
+		}
+
+		switch path[i] {
+		case '{':
+			end, err := closingBrace(path, i)
+			if err != nil {
+				return nil, err
+			}
+			current.WriteString(path[i : end+1])
+			i = end
+		case '}':
+			return nil, fmt.Errorf(
+				"invalid route %q: unexpected '}'", path)
+		case '[':
+			if optional || !strings.HasPrefix(path[i:], "[/") {
+				return nil, fmt.Errorf(
+					"invalid route %q: optional segments must be "+
+						"written as '[/segment]'", path)
+			}
+			if i > 0 && path[i-1] != ']' {
+				if err := flush(); err != nil {
+					return nil, err
+				}
+			}
+			optional = true
+			i++
+		case ']':
+			if !optional {
+				return nil, fmt.Errorf(
+					"invalid route %q: unexpected ']'", path)
+			}
+			if err := flush(); err != nil {
+				return nil, err
+			}
+			optional = false
+			closed = true
+		case '/':
+			if optional {
+				return nil, fmt.Errorf(
+					"invalid route %q: optional segments can only "+
+						"contain a single path segment", path)
+			}
+			if err := flush(); err != nil {
+				return nil, err
+			}
+		default:
+			current.WriteByte(path[i])
+		}
+	}
+
+	if optional {
+		return nil, fmt.Errorf("invalid route %q: missing ']'", path)
+	}
+	if !closed {
+		if err := flush(); err != nil {
+			return nil, err
+		}
+	}
+
+	seen := map[string]bool{}
+	for i, seg := range res {
+		if seg.catchAll && i != len(res)-1 {
+			return nil, fmt.Errorf(
+				"invalid route %q: catch-all parameter must be the "+
+					"last path segment", path)
+		}
+		if seg.optional && i == 0 && len(res) > 1 {
+			return nil, fmt.Errorf(
+				"invalid route %q: the first path segment cannot "+
+					"be optional", path)
+		}
+		for _, name := range seg.names {
+			if seen[name] {
+				return nil, fmt.Errorf(
+					"invalid route %q: duplicate parameter %q",
+					path, name)
+			}
+			seen[name] = true
+		}
+	}
+
+	return res, nil
+}
+
+// closingBrace returns the index of the brace closing the brace found
+// at index start in path, allowing for nested braces within regular
+// expression constraints.
+func closingBrace(path string, start int) (int, error) {
+	depth := 0
+	for i := start; i < len(path); i++ {
+		switch path[i] {
+		case '\\':
+			i++
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i, nil
+			}
+		}
+	}
+
+	return 0, fmt.Errorf("invalid route %q: missing '}'", path)
+}
+
+// parseSegment parses a single raw path segment.
+func parseSegment(raw string, optional bool) (*segment, error) {
+	seg := &segment{raw: raw, optional: optional}
+
+	whole := false
+	constrained := false
+	for i := 0; i < len(raw); i++ {
+		if raw[i] != '{' {
+			end := strings.IndexByte(raw[i:], '{')
+			if end < 0 {
+				end = len(raw) - i
+			}
+			seg.expr += regexp.QuoteMeta(raw[i : i+end])
+			i += end - 1
+			continue
+		}
+
+		end, err := closingBrace(raw, i)
+		if err != nil {
+			return nil, err
+		}
+		whole = i == 0 && end == len(raw)-1
+
+		name, constraint := raw[i+1:end], ""
+		if idx := strings.IndexByte(name, ':'); idx >= 0 {
+			name, constraint = name[:idx], name[idx+1:]
+		}
+
+		switch {
+		case constraint == "" && strings.HasSuffix(name, "..."):
+			if !whole {
+				return nil, fmt.Errorf(
+					"invalid parameter %q: a catch-all parameter "+
+						"must make up an entire path segment", raw)
+			}
+			name = strings.TrimSuffix(name, "...")
+			seg.catchAll = true
+			constraint = `.+`
+		case constraint == "":
+			constraint = `[^/]+`
+		default:
+			if t, exists := ParamTypes[constraint]; exists {
+				constraint = t
+			}
+			if _, err := regexp.Compile(constraint); err != nil {
+				return nil, fmt.Errorf(
+					"invalid parameter %q: %v", raw, err)
+			}
+			constrained = true
+		}
+
+		if !paramNameExpr.MatchString(name) {
+			return nil, fmt.Errorf(
+				"invalid parameter %q: name must only contain "+
+					"letters, digits and underscores", raw)
+		}
+
+		seg.names = append(seg.names, name)
+		seg.expr += `(?P<` + name + `>` + constraint + `)`
+		i = end
+	}
+
+	if seg.isStatic() {
+		return seg, nil
+	}
+
+	if whole {
+		seg.name = seg.names[0]
+		if !constrained {
+			return seg, nil
+		}
+	}
+
+	match, err := regexp.Compile("^(?:" + seg.expr + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("invalid parameter %q: %v", raw, err)
+	}
+	seg.match = match
+
+	return seg, nil
+}
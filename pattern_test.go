@@ -0,0 +1,48 @@
+package galago
+
+import "testing"
+
+func TestParsePath(t *testing.T) {
+	valid := []string{
+		"",
+		"users",
+		"users/{id}",
+		"users/{id:int}",
+		"posts/{slug:[a-z-]+}",
+		"codes/{code:[0-9]{3}}",
+		"commits[/{id}]",
+		"users[/{id}][/{action}]",
+		"users[/{id}]/edit",
+		"files/{path...}",
+		"files[/{path...}]",
+		"docs/{name}.{ext}",
+	}
+	for _, path := range valid {
+		if _, err := parsePath(path); err != nil {
+			t.Errorf("%q: unexpected error: %v", path, err)
+		}
+		if _, err := compilePath(path); err != nil {
+			t.Errorf("%q: failed to compile: %v", path, err)
+		}
+	}
+
+	invalid := []string{
+		"users/{id",
+		"users/{id}}",
+		"users[/{id}",
+		"users[{id}]",
+		"users[/{id}]x",
+		"users[/a/b]",
+		"[/{id}]/edit",
+		"{path...}/edit",
+		"files/x{path...}",
+		"users/{id:(}",
+		"users/{id}/{id}",
+		"users/{a-b}",
+	}
+	for _, path := range invalid {
+		if _, err := parsePath(path); err == nil {
+			t.Errorf("%q: expected an error", path)
+		}
+	}
+}
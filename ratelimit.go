@@ -0,0 +1,408 @@
+package galago
+
+import (
+	"container/list"
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// DefaultClientLimitTTL is the amount of time a client can remain idle
+// before its rate limit state is evicted when no TTL has been
+// configured.
+const DefaultClientLimitTTL = 10 * time.Minute
+
+// DefaultClientLimitMaxEntries is the maximum number of clients for
+// which rate limit state is kept when no maximum has been configured.
+const DefaultClientLimitMaxEntries = 100000
+
+// RateLimitStore keeps track of the requests made under a rate limit
+// for each key, usually the client ID generated by the
+// ClientIDFactory of the App. Implementations must be safe for
+// concurrent use.
+//
+// GalaGo provides in-memory implementations in TokenBucketStore,
+// SlidingWindowStore and FixedWindowStore. To enforce a single rate
+// limit across several processes, implement this interface on top of
+// storage that is shared between them.
+type RateLimitStore interface {
+	// Take consumes a single request for the specified key and
+	// returns the outcome. If an error is returned, the request is
+	// allowed. The context is that of the request being limited, so
+	// a store backed by a network service should stop waiting for it
+	// once the context is done.
+	Take(ctx context.Context, key string) (RateLimitResult, error)
+}
+
+// RateLimitResult describes the outcome of consuming a request from a
+// RateLimitStore.
+type RateLimitResult struct {
+	// Whether or not the request is allowed.
+	Allowed bool
+	// The maximum number of requests that can be made in a burst or
+	// window.
+	Limit int
+	// The number of requests that can still be made right now.
+	Remaining int
+	// The amount of time until the limit is completely reset.
+	Reset time.Duration
+	// The amount of time the client should wait before retrying a
+	// request that was not allowed.
+	RetryAfter time.Duration
+}
+
+// setHeaders sets the rate limit headers describing this result on
+// the specified response headers.
+func (res RateLimitResult) setHeaders(h http.Header) {
+	h.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+	if !res.Allowed {
+		h.Set("Retry-After", strconv.Itoa(ceilSeconds(res.RetryAfter)))
+	}
+}
+
+// takeFrom consumes a request for the specified key from the store.
+// If the store is nil, or fails, the request is allowed.
+func takeFrom(
+	ctx context.Context, store RateLimitStore, key string,
+) RateLimitResult {
+	if store == nil {
+		return RateLimitResult{Allowed: true}
+	}
+
+	res, err := store.Take(ctx, key)
+	if err != nil {
+		if logger != nil {
+			logger.Printf("error : rate limit store failed: %v\n", err)
+		}
+		return RateLimitResult{Allowed: true}
+	}
+
+	return res
+}
+
+// TokenBucketStore is an in-memory RateLimitStore that gives each key
+// its own token bucket using a rate.Limiter.
+type TokenBucketStore struct {
+	// The rate at which tokens are added to each bucket.
+	Limit rate.Limit
+	// The maximum number of tokens held in each bucket.
+	Burst int
+	// The amount of time a key can remain idle before its state is
+	// evicted. Defaults to DefaultClientLimitTTL.
+	TTL time.Duration
+	// The maximum number of keys for which state is kept. Once
+	// reached, the least recently seen key is evicted. Defaults to
+	// DefaultClientLimitMaxEntries.
+	MaxEntries int
+	entries    memoryEntries
+}
+
+// NewTokenBucketStore creates a new TokenBucketStore with the
+// specified rate and burst.
+func NewTokenBucketStore(limit rate.Limit, burst int) *TokenBucketStore {
+	return &TokenBucketStore{Limit: limit, Burst: burst}
+}
+
+// Take consumes a single token from the bucket for the specified key.
+func (store *TokenBucketStore) Take(
+	ctx context.Context, key string,
+) (RateLimitResult, error) {
+	var res RateLimitResult
+	store.entries.with(
+		key, store.TTL, store.MaxEntries,
+		func() interface{} {
+			return rate.NewLimiter(store.Limit, store.Burst)
+		},
+		func(state interface{}, now time.Time) {
+			res = takeToken(state.(*rate.Limiter), now)
+		},
+	)
+
+	return res, nil
+}
+
+// SlidingWindowStore is an in-memory RateLimitStore that allows each
+// key a fixed number of requests within any period of the configured
+// window, keeping a log of the time of each request.
+type SlidingWindowStore struct {
+	// The number of requests allowed within the window.
+	Limit int
+	// The length of the window.
+	Window time.Duration
+	// The amount of time a key can remain idle before its state is
+	// evicted. This is never less than Window. Defaults to
+	// DefaultClientLimitTTL.
+	TTL time.Duration
+	// The maximum number of keys for which state is kept. Once
+	// reached, the least recently seen key is evicted. Defaults to
+	// DefaultClientLimitMaxEntries.
+	MaxEntries int
+	entries    memoryEntries
+}
+
+// NewSlidingWindowStore creates a new SlidingWindowStore allowing the
+// specified number of requests within the window.
+func NewSlidingWindowStore(
+	limit int, window time.Duration,
+) *SlidingWindowStore {
+	return &SlidingWindowStore{Limit: limit, Window: window}
+}
+
+// Take records a request for the specified key if fewer than Limit
+// requests have been made within the last Window.
+func (store *SlidingWindowStore) Take(
+	ctx context.Context, key string,
+) (RateLimitResult, error) {
+	ttl := store.TTL
+	if ttl < store.Window {
+		ttl = store.Window
+	}
+
+	var res RateLimitResult
+	store.entries.with(
+		key, ttl, store.MaxEntries,
+		func() interface{} { return &[]time.Time{} },
+		func(state interface{}, now time.Time) {
+			log := state.(*[]time.Time)
+			requests := *log
+
+			// Discard any requests that have left the window.
+			cutoff := now.Add(-store.Window)
+			for len(requests) > 0 && !requests[0].After(cutoff) {
+				requests = requests[1:]
+			}
+
+			res = RateLimitResult{Allowed: true, Limit: store.Limit}
+			if len(requests) < store.Limit {
+				requests = append(requests, now)
+			} else {
+				res.Allowed = false
+				if len(requests) > 0 {
+					res.RetryAfter = requests[0].Add(store.Window).Sub(now)
+				}
+			}
+
+			res.Remaining = store.Limit - len(requests)
+			if len(requests) > 0 {
+				last := requests[len(requests)-1]
+				res.Reset = last.Add(store.Window).Sub(now)
+			}
+			*log = requests
+		},
+	)
+
+	return res, nil
+}
+
+// FixedWindowStore is an in-memory RateLimitStore that allows each key
+// a fixed number of requests within each consecutive window, counting
+// requests from the start of the window.
+type FixedWindowStore struct {
+	// The number of requests allowed within each window.
+	Limit int
+	// The length of each window.
+	Window time.Duration
+	// The amount of time a key can remain idle before its state is
+	// evicted. This is never less than Window. Defaults to
+	// DefaultClientLimitTTL.
+	TTL time.Duration
+	// The maximum number of keys for which state is kept. Once
+	// reached, the least recently seen key is evicted. Defaults to
+	// DefaultClientLimitMaxEntries.
+	MaxEntries int
+	entries    memoryEntries
+}
+
+// fixedWindow is the state kept for each key in a FixedWindowStore.
+type fixedWindow struct {
+	start time.Time
+	count int
+}
+
+// NewFixedWindowStore creates a new FixedWindowStore allowing the
+// specified number of requests within each window.
+func NewFixedWindowStore(limit int, window time.Duration) *FixedWindowStore {
+	return &FixedWindowStore{Limit: limit, Window: window}
+}
+
+// Take counts a request for the specified key if fewer than Limit
+// requests have been made within the current window.
+func (store *FixedWindowStore) Take(
+	ctx context.Context, key string,
+) (RateLimitResult, error) {
+	ttl := store.TTL
+	if ttl < store.Window {
+		ttl = store.Window
+	}
+
+	var res RateLimitResult
+	store.entries.with(
+		key, ttl, store.MaxEntries,
+		func() interface{} { return &fixedWindow{} },
+		func(state interface{}, now time.Time) {
+			window := state.(*fixedWindow)
+			if start := now.Truncate(store.Window); start.After(window.start) {
+				window.start = start
+				window.count = 0
+			}
+
+			res = RateLimitResult{
+				Allowed: window.count < store.Limit,
+				Limit:   store.Limit,
+				Reset:   window.start.Add(store.Window).Sub(now),
+			}
+			if res.Allowed {
+				window.count++
+			} else {
+				res.RetryAfter = res.Reset
+			}
+			res.Remaining = store.Limit - window.count
+		},
+	)
+
+	return res, nil
+}
+
+// limiterStore is a RateLimitStore that applies a single rate.Limiter
+// to every key. It is used for the GlobalLimit of an App.
+type limiterStore struct {
+	limiter *rate.Limiter
+}
+
+// Take consumes a single token from the Limiter.
+func (store limiterStore) Take(
+	ctx context.Context, key string,
+) (RateLimitResult, error) {
+	return takeToken(store.limiter, time.Now()), nil
+}
+
+// takeToken reserves a single token from the specified Limiter. If the
+// token would not be available immediately, the reservation is
+// cancelled and the result reports how long the client should wait.
+func takeToken(limiter *rate.Limiter, now time.Time) RateLimitResult {
+	res := RateLimitResult{Allowed: true, Limit: limiter.Burst()}
+
+	reservation := limiter.ReserveN(now, 1)
+	if !reservation.OK() {
+		res.Allowed = false
+		res.RetryAfter = time.Duration(math.MaxInt64)
+	} else if delay := reservation.DelayFrom(now); delay > 0 {
+		reservation.CancelAt(now)
+		res.Allowed = false
+		res.RetryAfter = delay
+	}
+
+	tokens := limiter.TokensAt(now)
+	if tokens > 0 {
+		res.Remaining = int(tokens)
+	}
+
+	if limit := limiter.Limit(); limit > 0 && limit != rate.Inf {
+		missing := float64(res.Limit) - tokens
+		res.Reset = time.Duration(
+			missing / float64(limit) * float64(time.Second))
+	}
+
+	return res
+}
+
+// ceilSeconds converts the specified duration to a whole number of
+// seconds, rounding up.
+func ceilSeconds(d time.Duration) int {
+	if d <= 0 {
+		return 0
+	}
+	if d > time.Duration(math.MaxInt32)*time.Second {
+		return math.MaxInt32
+	}
+
+	return int((d + time.Second - 1) / time.Second)
+}
+
+// memoryEntries is a concurrency-safe collection of rate limit state,
+// one for each key, used by the in-memory RateLimitStores. Keys that
+// have been idle for longer than the TTL are evicted, and once the
+// maximum number of entries is reached the least recently seen key is
+// evicted to make room for a new one. An evicted key starts again
+// with fresh state. The zero value is ready to use.
+type memoryEntries struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// The keys ordered from most to least recently seen.
+	recent *list.List
+}
+
+// memoryEntry is the state for a single key within memoryEntries.
+type memoryEntry struct {
+	key   string
+	state interface{}
+	seen  time.Time
+}
+
+// with calls fn with the state for the specified key while holding
+// the lock, creating the state using create if it does not exist. If
+// ttl or max are not positive, DefaultClientLimitTTL and
+// DefaultClientLimitMaxEntries are used.
+func (m *memoryEntries) with(
+	key string, ttl time.Duration, max int,
+	create func() interface{}, fn func(interface{}, time.Time),
+) {
+	if ttl <= 0 {
+		ttl = DefaultClientLimitTTL
+	}
+	if max <= 0 {
+		max = DefaultClientLimitMaxEntries
+	}
+
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.entries == nil {
+		m.entries = map[string]*list.Element{}
+		m.recent = list.New()
+	}
+
+	m.evict(now, ttl)
+
+	elem, exists := m.entries[key]
+	if exists {
+		m.recent.MoveToFront(elem)
+	} else {
+		for m.recent.Len() >= max {
+			m.remove(m.recent.Back())
+		}
+		elem = m.recent.PushFront(&memoryEntry{key: key, state: create()})
+		m.entries[key] = elem
+	}
+
+	entry := elem.Value.(*memoryEntry)
+	entry.seen = now
+	fn(entry.state, now)
+}
+
+// evict removes any keys that have been idle for longer than ttl. The
+// caller must hold the lock.
+func (m *memoryEntries) evict(now time.Time, ttl time.Duration) {
+	for elem := m.recent.Back(); elem != nil; elem = m.recent.Back() {
+		if now.Sub(elem.Value.(*memoryEntry).seen) <= ttl {
+			return
+		}
+		m.remove(elem)
+	}
+}
+
+// remove removes the specified element. The caller must hold the
+// lock.
+func (m *memoryEntries) remove(elem *list.Element) {
+	m.recent.Remove(elem)
+	delete(m.entries, elem.Value.(*memoryEntry).key)
+}
@@ -0,0 +1,274 @@
+package galago
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestRateLimitConcurrentRequests(t *testing.T) {
+	const clients, requests = 50, 40
+
+	route := NewRoute(http.MethodGet, "items", func(Request) *Response {
+		return NewResponse(http.StatusOK, nil)
+	})
+	route.Limit = rate.NewLimiter(rate.Inf, 1)
+
+	app := &App{
+		ClientLimit:           rate.NewLimiter(rate.Inf, 1),
+		ClientLimitMaxEntries: clients / 2,
+		ClientIDFactory: func(r *http.Request) string {
+			return r.Header.Get("X-Client")
+		},
+	}
+	app.AddController(NewController().AddRoute(route))
+
+	var wg sync.WaitGroup
+	for c := 0; c < clients; c++ {
+		wg.Add(1)
+		go func(client string) {
+			defer wg.Done()
+			for i := 0; i < requests; i++ {
+				r := httptest.NewRequest(http.MethodGet, "/items", nil)
+				r.Header.Set("X-Client", client)
+				w := httptest.NewRecorder()
+				app.ServeHTTP(w, r)
+				if w.Code != http.StatusOK {
+					t.Errorf("expected status 200, got %v", w.Code)
+					return
+				}
+			}
+		}(fmt.Sprint("client-", c))
+	}
+	wg.Wait()
+
+	for _, store := range []RateLimitStore{app.clientLimits, route.limits} {
+		entries := &store.(*TokenBucketStore).entries
+		entries.mu.Lock()
+		n := len(entries.entries)
+		entries.mu.Unlock()
+		if n > clients/2 {
+			t.Errorf("expected at most %v clients, got %v", clients/2, n)
+		}
+	}
+}
+
+func TestMemoryEntriesTTL(t *testing.T) {
+	const ttl = 20 * time.Millisecond
+
+	var m memoryEntries
+	created := 0
+	create := func() interface{} {
+		created++
+		return created
+	}
+	take := func(key string) {
+		m.with(key, ttl, 0, create, func(interface{}, time.Time) {})
+	}
+
+	take("a")
+	take("b")
+	take("a")
+	if created != 2 {
+		t.Fatalf("expected 2 entries to be created, got %v", created)
+	}
+
+	time.Sleep(2 * ttl)
+
+	take("c")
+	if _, exists := m.entries["a"]; exists {
+		t.Error("expected idle entry a to be evicted")
+	}
+	if _, exists := m.entries["b"]; exists {
+		t.Error("expected idle entry b to be evicted")
+	}
+	if len(m.entries) != 1 || m.recent.Len() != 1 {
+		t.Errorf("expected 1 entry, got %v", len(m.entries))
+	}
+
+	take("a")
+	if created != 4 {
+		t.Errorf("expected an evicted entry to be created again, "+
+			"got %v entries created", created)
+	}
+}
+
+func TestMemoryEntriesMaxEntries(t *testing.T) {
+	const max = 3
+
+	var m memoryEntries
+	take := func(key string) {
+		m.with(key, time.Hour, max,
+			func() interface{} { return key },
+			func(interface{}, time.Time) {})
+	}
+
+	take("a")
+	take("b")
+	take("c")
+	take("a")
+	take("d")
+
+	if len(m.entries) != max || m.recent.Len() != max {
+		t.Fatalf("expected %v entries, got %v", max, len(m.entries))
+	}
+	if _, exists := m.entries["b"]; exists {
+		t.Error("expected least recently seen entry b to be evicted")
+	}
+	for _, key := range []string{"a", "c", "d"} {
+		if _, exists := m.entries[key]; !exists {
+			t.Errorf("expected entry %v to be kept", key)
+		}
+	}
+}
+
+// contextStore is a RateLimitStore recording the context it is passed.
+type contextStore struct {
+	ctx context.Context
+}
+
+func (store *contextStore) Take(
+	ctx context.Context, key string,
+) (RateLimitResult, error) {
+	store.ctx = ctx
+	return RateLimitResult{Allowed: true}, nil
+}
+
+func TestRateLimitStoreContext(t *testing.T) {
+	type key struct{}
+
+	route := NewRoute(http.MethodGet, "items", func(Request) *Response {
+		return NewResponse(http.StatusOK, nil)
+	})
+	route.LimitStore = &contextStore{}
+
+	app := &App{
+		GlobalLimitStore: &contextStore{},
+		ClientLimitStore: &contextStore{},
+		ClientIDFactory:  func(*http.Request) string { return "client" },
+	}
+	app.AddController(NewController().AddRoute(route))
+
+	r := httptest.NewRequest(http.MethodGet, "/items", nil)
+	r = r.WithContext(context.WithValue(r.Context(), key{}, "request"))
+	app.ServeHTTP(httptest.NewRecorder(), r)
+
+	stores := []RateLimitStore{
+		app.GlobalLimitStore, app.ClientLimitStore, route.LimitStore,
+	}
+	for i, store := range stores {
+		ctx := store.(*contextStore).ctx
+		if ctx == nil || ctx.Value(key{}) != "request" {
+			t.Errorf("store %v: expected the request context", i)
+		}
+	}
+}
+
+// headerCounter is a ResponseRecorder counting the number of times a
+// status is written.
+type headerCounter struct {
+	*httptest.ResponseRecorder
+	statuses int
+}
+
+// WriteHeader counts the status before recording it.
+func (hc *headerCounter) WriteHeader(status int) {
+	hc.statuses++
+	hc.ResponseRecorder.WriteHeader(status)
+}
+
+func TestRateLimitedResponse(t *testing.T) {
+	limiter := func() *rate.Limiter {
+		return rate.NewLimiter(rate.Every(time.Hour), 1)
+	}
+	clientID := func(*http.Request) string { return "client" }
+
+	tests := []struct {
+		name      string
+		configure func(app *App, route *Route)
+	}{
+		{"global", func(app *App, route *Route) {
+			app.GlobalLimit = limiter()
+		}},
+		{"client", func(app *App, route *Route) {
+			app.ClientLimit = limiter()
+			app.ClientIDFactory = clientID
+		}},
+		{"route", func(app *App, route *Route) {
+			route.Limit = limiter()
+			app.ClientIDFactory = clientID
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			handled, middleware := 0, 0
+
+			route := NewRoute(http.MethodGet, "items", func(Request) *Response {
+				handled++
+				return NewResponse(http.StatusOK, nil)
+			})
+			app := &App{}
+			app.AddMiddleware(Middleware{
+				Before: func(*Request) { middleware++ },
+			})
+			test.configure(app, route)
+			app.AddController(NewController().AddRoute(route))
+
+			for i := 0; i < 2; i++ {
+				w := &headerCounter{ResponseRecorder: httptest.NewRecorder()}
+				app.ServeHTTP(w, httptest.NewRequest(
+					http.MethodGet, "/items", nil))
+
+				if i == 0 {
+					if w.Code != http.StatusOK {
+						t.Fatalf("expected status 200, got %v", w.Code)
+					}
+					continue
+				}
+
+				if w.Code != http.StatusTooManyRequests {
+					t.Fatalf("expected status 429, got %v", w.Code)
+				}
+				if w.statuses != 1 {
+					t.Errorf("expected the status to be written once, "+
+						"got %v", w.statuses)
+				}
+
+				expected := map[string]string{
+					"RateLimit-Limit":     "1",
+					"RateLimit-Remaining": "0",
+					"RateLimit-Reset":     "3600",
+					"Retry-After":         "3600",
+					"Content-Type":        "application/json",
+				}
+				for name, value := range expected {
+					if actual := w.Header().Get(name); actual != value {
+						t.Errorf("expected %v %q, got %q", name, value, actual)
+					}
+				}
+
+				body := strings.TrimSpace(w.Body.String())
+				if body != `{"error":"too many requests"}` {
+					t.Errorf("expected a serialized error, got %q", body)
+				}
+			}
+
+			if handled != 1 {
+				t.Errorf("expected the handler to run once, ran %v times",
+					handled)
+			}
+			if middleware != 1 {
+				t.Errorf("expected the middleware to run once, ran %v times",
+					middleware)
+			}
+		})
+	}
+}
@@ -12,7 +12,8 @@ type Request struct {
 	Path string
 	// The Route matching the Request.
 	Route *Route
-	// The Data passed to the request with `-d`
+	// The Data passed to the request with `-d`, or the values of the
+	// submitted HTML form.
 	Data map[string]interface{}
 	// The Query Parameters passed to the Request.
 	Params map[string]string
@@ -20,6 +21,12 @@ type Request struct {
 	Headers map[string]string
 	// The lower level http.Request structure.
 	HTTPRequest *http.Request
+	// The Route Parameters captured while routing the Request.
+	fields map[string]string
+	// The files uploaded with the Request in a multipart form.
+	files []*FormFile
+	// The App that received the Request.
+	app *App
 }
 
 // RequestQuery1D Converts a url.Values structure into a one
@@ -58,7 +65,10 @@ func (request *Request) Redirect(url string, status int) *Response {
 // GetField returns a pointer to the value for the Route Parameter
 // matching the specified key. If none exists, nil is returned.
 func (request *Request) GetField(key string) *string {
-	pathProperties := request.Route.parseProperties(request.Path)
+	pathProperties := request.fields
+	if pathProperties == nil {
+		pathProperties = request.Route.parseProperties(request.Path)
+	}
 	if val, exists := pathProperties[key]; exists {
 		if val != "" {
 			return &val
@@ -11,8 +11,10 @@ type Response struct {
 	// The Headers for the response. Easily set headers using the
 	// response.SetHeader(key, val) function.
 	Headers map[string]string
-	// The response data.
-	Data map[string]interface{}
+	// The response data. This can be any value that the Serializer
+	// for the Response is able to serialize. Serializers that only
+	// support maps require a map[string]interface{}.
+	Data interface{}
 	// The response Serializer. Easily set the Serializer using the
 	// response.SetSerializer(serializer) function.
 	Serializer *Serializer
@@ -20,11 +22,19 @@ type Response struct {
 	isRedirect bool
 	// The location to which to redirect. Can be a relative path.
 	redirectTo string
+	// The function that streams the body of the response, if it is a
+	// streaming response.
+	stream StreamFunc
+	// The function that is passed the WebSocket once the connection
+	// has been upgraded, if the response upgrades it.
+	upgrade func(*WebSocket) error
+	// The file sent in the body of the response, if it serves a file.
+	content *fileContent
 }
 
 // NewResponse creates a new response using the specified HTTP Status
 // code and Data.
-func NewResponse(status int, data map[string]interface{}) *Response {
+func NewResponse(status int, data interface{}) *Response {
 	return &Response{
 		HTTPStatus: status,
 		Headers:    map[string]string{},
@@ -0,0 +1,122 @@
+package galago
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+)
+
+func TestResponsesSkipSerializers(t *testing.T) {
+	configs := []struct {
+		name      string
+		configure func(app *App)
+		headers   http.Header
+	}{
+		{"raw serializer", func(app *App) {
+			app.Serializer = TextSerializer()
+		}, nil},
+		{"unacceptable media type", func(app *App) {
+			app.RegisterXML("")
+		}, http.Header{"Accept": {"text/css"}}},
+	}
+
+	for _, config := range configs {
+		t.Run(config.name, func(t *testing.T) {
+			fsys := &trackingFS{FS: fstest.MapFS{
+				"site.css": &fstest.MapFile{Data: []byte("body{}")},
+			}}
+
+			app := &App{}
+			config.configure(app)
+			app.AddController(NewController().
+				AddRoute(NewRoute(http.MethodGet, "redirect",
+					func(request Request) *Response {
+						return request.Redirect("/target", http.StatusFound)
+					})).
+				AddRoute(NewRoute(http.MethodGet, "stream",
+					func(Request) *Response {
+						return NewStreamResponse(http.StatusOK,
+							func(stream *Stream) error {
+								_, err := io.WriteString(stream, "streamed")
+								return err
+							}).SetHeader("Content-Type", "text/plain")
+					})).
+				AddRoute(NewWebSocketRoute("ws",
+					func(Request, *WebSocket) error { return nil })).
+				AddRoute(NewStaticRoute("assets", fsys)))
+
+			server := httptest.NewServer(app)
+			defer server.Close()
+
+			serve := func(path string) *httptest.ResponseRecorder {
+				r := httptest.NewRequest(http.MethodGet, path, nil)
+				for k, v := range config.headers {
+					r.Header[k] = v
+				}
+				w := httptest.NewRecorder()
+				app.ServeHTTP(w, r)
+				return w
+			}
+
+			tests := []struct {
+				name  string
+				check func(t *testing.T)
+			}{
+				{"redirect", func(t *testing.T) {
+					w := serve("/redirect")
+					if w.Code != http.StatusFound {
+						t.Errorf("expected status 302, got %v", w.Code)
+					}
+					location := w.Header().Get("Location")
+					if location != "/target" {
+						t.Errorf("expected Location /target, got %q", location)
+					}
+				}},
+				{"file", func(t *testing.T) {
+					w := serve("/assets/site.css")
+					if w.Code != http.StatusOK || w.Body.String() != "body{}" {
+						t.Errorf("expected the file, got %v: %q",
+							w.Code, w.Body.String())
+					}
+					ct := w.Header().Get("Content-Type")
+					if ct != "text/css; charset=utf-8" {
+						t.Errorf("expected text/css, got %q", ct)
+					}
+					if open := atomic.LoadInt32(&fsys.open); open != 0 {
+						t.Errorf("expected every file to be closed, %v open", open)
+					}
+				}},
+				{"stream", func(t *testing.T) {
+					w := serve("/stream")
+					if w.Code != http.StatusOK || w.Body.String() != "streamed" {
+						t.Errorf("expected the stream, got %v: %q",
+							w.Code, w.Body.String())
+					}
+					if ct := w.Header().Get("Content-Type"); ct != "text/plain" {
+						t.Errorf("expected text/plain, got %q", ct)
+					}
+				}},
+				{"upgrade", func(t *testing.T) {
+					res := dialWebSocket(t, server, "/ws", config.headers)
+					if res.StatusCode != http.StatusSwitchingProtocols {
+						body, _ := io.ReadAll(res.Body)
+						t.Fatalf("expected status 101, got %v: %s",
+							res.StatusCode, body)
+					}
+					expected := "s3pPLMBiTxaQ9kYGzzhZRbK+xOo="
+					accept := res.Header.Get("Sec-WebSocket-Accept")
+					if accept != expected {
+						t.Errorf("expected accept %v, got %v", expected, accept)
+					}
+				}},
+			}
+
+			for _, test := range tests {
+				t.Run(test.name, test.check)
+			}
+		})
+	}
+}
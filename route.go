@@ -1,9 +1,9 @@
 package galago
 
 import (
+	"fmt"
 	"net/http"
 	"regexp"
-	"strings"
 
 	"golang.org/x/time/rate"
 )
@@ -20,15 +20,24 @@ type Route struct {
 	// parameters using `{name}` format. For example, `users/{id}`.
 	// Later, you can retrieve this value from the Request using
 	// request.GetField("name").
+	//
+	// Route parameters can be constrained to a type or a regular
+	// expression using `{name:type}` format, for example
+	// `users/{id:int}` or `posts/{slug:[a-z-]+}`. A catch-all
+	// parameter matching the remainder of the path can be specified
+	// using `{name...}` format, for example `files/{path...}`.
 	Path string
-	// The regex match for the route. This is used to match incoming
-	// URIs to the path of this Route.
+	// The anchored regex match for the route. This is nil if the
+	// path for the Route is invalid.
 	Match *regexp.Regexp
 	// Processes a request that is sent to this Route.
 	Handler RouteHandler
 	// A list of all Middleware that is applied to this Route. You
 	// can add Middleware to this route Easily using the
 	// Route.AddMiddleware() function.
+	//
+	// This does not include any Middleware the Route inherits from
+	// the Controller it belongs to.
 	Middleware []Middleware
 	// The Serializer to use for Input / Output. See Serializer for
 	// a more comprehensive description of what presedence serializers
@@ -36,9 +45,27 @@ type Route struct {
 	Serializer *Serializer
 	// The Limiter applied to each client that requests this Route.
 	// This Limiter is copied into a new Limiter for each client, so
-	// it is only used as a reference for other Limiters.
-	Limit        *rate.Limiter
-	clientLimits map[string]*rate.Limiter
+	// it is only used as a reference for other Limiters. This is
+	// ignored if LimitStore is set.
+	Limit *rate.Limiter
+	// The store used for the rate limit applied to each client that
+	// requests this Route. Requests are counted against the key
+	// generated by the ClientIDFactory of the App.
+	LimitStore RateLimitStore
+	// The maximum size of a request body sent to this Route in bytes,
+	// overriding the MaxBodyBytes of the App. Use a negative value to
+	// remove the limit.
+	MaxBodyBytes int64
+	// The effective rate limit store for this Route, resolved when
+	// the App is compiled.
+	limits RateLimitStore
+	// The effective Middleware chain for this Route, made up of the
+	// Route's own Middleware followed by any Middleware inherited
+	// from its Controller. This is resolved when the App is compiled.
+	chain []Middleware
+	// Whether or not this Route is only matched by GET and HEAD
+	// requests that do not match any other Route.
+	fallback bool
 }
 
 // RouteHandler handles Requests sent to a Route.
@@ -49,49 +76,51 @@ type RouteCollection []*Route
 
 // NewRoute creates a new Route with the specified HTTP method, path
 // and RouteHandler.
+//
+// Each Route Parameter in the path matches exactly one path segment
+// unless it is a catch-all parameter. See ParamTypes for the
+// constraints that can be applied to a Route Parameter. If the path
+// is invalid, the App the Route is added to will fail to start, and
+// Err returns the reason.
 func NewRoute(method, path string, handler RouteHandler) *Route {
-	routeRegex := path
-
-	var re = regexp.MustCompile(`(?m)(\[\/|)\{([a-zA-Z0-9]+)\}(\]|)`)
-	for _, match := range re.FindAllStringSubmatch(path, -1) {
-		optional := match[1] == "[/" && match[3] == "]"
-		var substitution string
-		if optional {
-			substitution = `(\/|)(?P<` + match[2] + `>.+|)`
-		} else {
-			substitution = `(?P<` + match[2] + `>.+)`
-		}
-		routeRegex = strings.Replace(
-			routeRegex, match[0], substitution, -1)
-	}
+	match, _ := compilePath(path)
 
 	return &Route{
 		Method:     method,
 		Path:       path,
-		Match:      regexp.MustCompile(routeRegex),
+		Match:      match,
 		Handler:    handler,
 		Middleware: []Middleware{},
 	}
 }
 
-// Allowed determines if any rate limits are restricting the client
-// specified in the result of the ClientIDFactory.
-func (route *Route) allowed(c ClientIDFactory, r *http.Request) bool {
-	if route.Limit != nil {
-		clientid := c(r)
-		if route.clientLimits == nil {
-			route.clientLimits = map[string]*rate.Limiter{}
-		}
+// Err returns the error that prevents this Route from being
+// registered, or nil if its path is valid. An App with an invalid
+// Route fails to start, and responds to every request with a 500
+// Internal Server Error if it is served without being started.
+func (route *Route) Err() error {
+	if _, err := parsePath(route.Path); err != nil {
+		return registrationError(route, err)
+	}
 
-		if _, exists := route.clientLimits[clientid]; !exists {
-			route.clientLimits[clientid] = rate.NewLimiter(
-				route.Limit.Limit(), route.Limit.Burst())
-		}
+	return nil
+}
+
+// registrationError returns the error reported when the specified
+// Route can not be registered.
+func registrationError(route *Route, err error) error {
+	return fmt.Errorf(
+		"failed to register route %v %v: %v", route.Method, route.Path, err)
+}
 
-		return route.clientLimits[clientid].Allow()
+// limit consumes a request from the rate limit for the client
+// specified in the result of the ClientIDFactory.
+func (route *Route) limit(c ClientIDFactory, r *http.Request) RateLimitResult {
+	if route.limits == nil {
+		return RateLimitResult{Allowed: true}
 	}
 
-	return true
+	return takeFrom(r.Context(), route.limits, c(r))
 }
 
 // AddMiddleware adds the specified Middleware to the Route.
@@ -100,18 +129,42 @@ func (route *Route) AddMiddleware(middleware Middleware) *Route {
 	return route
 }
 
+// resolve sets the effective Middleware chain for this Route to its
+// own Middleware followed by the specified inherited Middleware. Any
+// previously inherited Middleware is replaced.
+func (route *Route) resolve(inherited []Middleware) {
+	chain := make([]Middleware, 0, len(route.Middleware)+len(inherited))
+	chain = append(chain, route.Middleware...)
+	route.chain = append(chain, inherited...)
+}
+
+// middleware returns the effective Middleware chain for this Route.
+// If the Route has not yet been resolved, only the Route's own
+// Middleware is returned.
+func (route *Route) middleware() []Middleware {
+	if route.chain == nil {
+		return route.Middleware
+	}
+
+	return route.chain
+}
+
 // isURL determines if the URL specified in url matches the Path
 // set for this Route.
 func (route *Route) isURL(url string) bool {
-	return route.Match.MatchString(url)
+	return route.Match != nil && route.Match.MatchString(url)
 }
 
 // parseProperties parses the the route parameters found in a URL
 // matching this Route. You should first determine if the URl matches
 // this Route using IsUrl().
 func (route *Route) parseProperties(url string) map[string]string {
-	match := route.Match.FindStringSubmatch(url)
 	properties := make(map[string]string)
+	if route.Match == nil {
+		return properties
+	}
+
+	match := route.Match.FindStringSubmatch(url)
 	for i, name := range route.Match.SubexpNames() {
 		if i > 0 && i <= len(match) {
 			properties[name] = match[i]
@@ -124,28 +177,12 @@ func (route *Route) parseProperties(url string) map[string]string {
 // handle will handle an incoming Request using this Route and return
 // a Response.
 //
-// First all Before functions from the Middleware applied to this
-// Route will be run on the Request, then the Request will be
-// processed and a Response will be generated. That Response will then
-// be run through all After functions from the Middleware applied to
-// this Route and once completed, the Response will be returned.
+// The Request is passed through the Middleware applied to this Route,
+// any of which can return a Response early, before it reaches the
+// RouteHandler. The Response is then passed back out through the same
+// Middleware before it is returned.
 func (route *Route) handle(request *Request) *Response {
-	// Process any "before" middleware
-	for _, mw := range route.Middleware {
-		if mw.Before != nil {
-			mw.Before(request)
-		}
-	}
-
-	// Process the request
-	response := route.Handler(*request)
-
-	// Process any "after" middleware
-	for _, mw := range route.Middleware {
-		if mw.After != nil {
-			mw.After(response)
-		}
-	}
-
-	return response
+	return wrapMiddleware(route.middleware(), func(r *Request) *Response {
+		return route.Handler(*r)
+	})(request)
 }
@@ -0,0 +1,292 @@
+package galago
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// router matches request paths to Routes using a prefix tree keyed by
+// path segment. It is built once from the Routes of an App so that
+// the cost of a lookup depends on the length of the path rather than
+// the number of Routes registered.
+type router struct {
+	root *node
+	// The tree holding fallback Routes, such as static Routes, which
+	// are only tried for GET and HEAD requests that match no other
+	// Route.
+	fallback *node
+}
+
+// node is a single path segment within the router tree.
+type node struct {
+	// Children that match a single static path segment.
+	static map[string]*node
+	// Children that capture one or more Route Parameters. These are
+	// tried in the order in which they were registered, and only
+	// after all static children have failed to match.
+	params []*node
+	// The child that captures the remainder of the path. This is
+	// only tried after all other children have failed to match.
+	catchAll *node
+	// The matcher for this node, if it is a parameter node.
+	segment *segment
+	// The Routes that terminate at this node, in registration order.
+	endpoints []*endpoint
+}
+
+// endpoint is a Route terminating at a node, along with the
+// parameter segments that were walked to reach it.
+type endpoint struct {
+	route  *Route
+	params []*segment
+	names  []string
+}
+
+// newRouter builds a router from the specified Routes. If the path
+// of any Route is invalid, an error is returned.
+func newRouter(routes RouteCollection) (*router, error) {
+	r := &router{root: &node{}, fallback: &node{}}
+	for _, route := range routes {
+		if err := r.add(route); err != nil {
+			return nil, registrationError(route, err)
+		}
+	}
+
+	return r, nil
+}
+
+// add inserts the specified Route into the tree. Each optional
+// segment in the Route path doubles the number of branches that
+// terminate at the Route.
+func (r *router) add(route *Route) error {
+	segments, err := parsePath(route.Path)
+	if err != nil {
+		return err
+	}
+
+	names := []string{}
+	for _, seg := range segments {
+		names = append(names, seg.names...)
+	}
+
+	root := r.root
+	if route.fallback {
+		root = r.fallback
+	}
+
+	for _, variant := range expandSegments(segments) {
+		n := root
+		params := []*segment{}
+		for _, seg := range variant {
+			n = n.child(seg)
+			if !seg.isStatic() {
+				params = append(params, seg)
+			}
+		}
+		n.endpoints = append(n.endpoints, &endpoint{
+			route: route, params: params, names: names,
+		})
+	}
+
+	return nil
+}
+
+// child retrieves the child of this node for the path segment,
+// creating it if it does not already exist.
+func (n *node) child(seg *segment) *node {
+	if seg.isStatic() {
+		if n.static == nil {
+			n.static = map[string]*node{}
+		}
+		if _, exists := n.static[seg.raw]; !exists {
+			n.static[seg.raw] = &node{}
+		}
+		return n.static[seg.raw]
+	}
+
+	if seg.catchAll {
+		if n.catchAll == nil {
+			n.catchAll = &node{segment: seg}
+		}
+		return n.catchAll
+	}
+
+	for _, param := range n.params {
+		if param.segment.raw == seg.raw {
+			return param
+		}
+	}
+
+	param := &node{segment: seg}
+	n.params = append(n.params, param)
+	return param
+}
+
+// lookup finds the first Route matching the specified request path
+// and method, along with the values for any Route Parameters it
+// captured. HEAD requests are matched to GET Routes when no HEAD
+// Route has been registered for the path.
+//
+// If no Route matches, nil is returned along with the methods that
+// are allowed for the path. If the path is unknown, no methods are
+// returned. Fallback Routes are only matched by GET and HEAD requests
+// for unknown paths, and are never included in the allowed methods.
+func (r *router) lookup(path, method string) (
+	*Route, map[string]string, []string,
+) {
+	segments := splitPath(path)
+
+	found, fields, allowed := r.root.find(segments, method)
+	if found != nil {
+		return found, fields, nil
+	}
+
+	if len(allowed) == 0 {
+		if method == http.MethodGet || method == http.MethodHead {
+			found, fields, _ = r.fallback.find(segments, method)
+		}
+		return found, fields, nil
+	}
+
+	if allowed[http.MethodGet] {
+		allowed[http.MethodHead] = true
+	}
+	allowed[http.MethodOptions] = true
+
+	methods := make([]string, 0, len(allowed))
+	for m := range allowed {
+		methods = append(methods, m)
+	}
+	sort.Strings(methods)
+
+	return nil, nil, methods
+}
+
+// find finds the first Route below this node matching the path
+// segments and method, along with the values for any Route Parameters
+// it captured. If no Route matches, nil is returned along with the
+// methods of the Routes matching the path.
+func (n *node) find(segments []string, method string) (
+	*Route, map[string]string, map[string]bool,
+) {
+	var found *Route
+	var fields map[string]string
+	allowed := map[string]bool{}
+
+	n.walk(segments, nil, func(n *node, values []string) bool {
+		ep := n.endpoint(method)
+		if ep == nil && method == http.MethodHead {
+			ep = n.endpoint(http.MethodGet)
+		}
+		if ep != nil {
+			found = ep.route
+			fields = ep.fields(values)
+			return true
+		}
+
+		for _, ep := range n.endpoints {
+			allowed[ep.route.Method] = true
+		}
+		return false
+	})
+
+	return found, fields, allowed
+}
+
+// endpoint returns the first endpoint at this node registered for the
+// specified method, or nil if there is none.
+func (n *node) endpoint(method string) *endpoint {
+	for _, ep := range n.endpoints {
+		if ep.route.Method == method {
+			return ep
+		}
+	}
+
+	return nil
+}
+
+// walk descends the tree along the specified path segments, calling
+// visit for every node at which the path terminates until visit
+// returns true. Static children take precedence over parameters. The
+// raw path segments captured by each parameter node along the way
+// are accumulated in values.
+func (n *node) walk(
+	segments []string, values []string,
+	visit func(*node, []string) bool,
+) bool {
+	if len(segments) == 0 {
+		return len(n.endpoints) > 0 && visit(n, values)
+	}
+
+	head, rest := segments[0], segments[1:]
+	if child, exists := n.static[head]; exists {
+		if child.walk(rest, values, visit) {
+			return true
+		}
+	}
+
+	for _, param := range n.params {
+		if param.segment.matches(head) {
+			if param.walk(rest, append(values, head), visit) {
+				return true
+			}
+		}
+	}
+
+	// An optional catch-all parameter also matches an empty remainder,
+	// such as the trailing slash of `files/` for `files[/{path...}]`.
+	if n.catchAll != nil {
+		remainder := strings.Join(segments, "/")
+		matches := remainder != "" || n.catchAll.segment.optional
+		if matches && len(n.catchAll.endpoints) > 0 {
+			return visit(n.catchAll, append(values, remainder))
+		}
+	}
+
+	return false
+}
+
+// fields maps the captured path segments onto the Route Parameter
+// names of this endpoint. Parameters in optional segments that were
+// omitted from the path are mapped to an empty string.
+func (ep *endpoint) fields(values []string) map[string]string {
+	res := make(map[string]string, len(ep.names))
+	for _, name := range ep.names {
+		res[name] = ""
+	}
+
+	for i, seg := range ep.params {
+		seg.capture(values[i], res)
+	}
+
+	return res
+}
+
+// splitPath splits a request path into its segments.
+func splitPath(path string) []string {
+	if path == "" {
+		return nil
+	}
+
+	return strings.Split(path, "/")
+}
+
+// expandSegments returns every combination of the specified path
+// segments with each optional segment either present or omitted.
+func expandSegments(segments []*segment) [][]*segment {
+	res := [][]*segment{{}}
+	for _, seg := range segments {
+		next := [][]*segment{}
+		for _, variant := range res {
+			with := append(append([]*segment{}, variant...), seg)
+			next = append(next, with)
+			if seg.optional {
+				next = append(next, variant)
+			}
+		}
+		res = next
+	}
+
+	return res
+}
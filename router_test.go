@@ -0,0 +1,212 @@
+package galago
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// benchmarkRoutes returns a few hundred Routes resembling those of a
+// large service, along with a path matching one of the last of them.
+func benchmarkRoutes() (RouteCollection, string) {
+	routes := RouteCollection{}
+	for i := 0; i < 100; i++ {
+		resource := fmt.Sprintf("api/v1/resource%d", i)
+		routes = append(routes,
+			NewRoute(http.MethodGet, resource, nil),
+			NewRoute(http.MethodGet, resource+"/{id:int}", nil),
+			NewRoute(http.MethodGet,
+				resource+"/{id:int}/items[/{item}]", nil),
+		)
+	}
+
+	return routes, "api/v1/resource99/42/items/7"
+}
+
+func BenchmarkRouterLookup(b *testing.B) {
+	routes, path := benchmarkRoutes()
+	router, err := newRouter(routes)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if route, _, _ := router.lookup(path, http.MethodGet); route == nil {
+			b.Fatal("no route found")
+		}
+	}
+}
+
+func BenchmarkLinearIsURL(b *testing.B) {
+	routes, path := benchmarkRoutes()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var found *Route
+		for _, route := range routes {
+			if route.isURL(path) && route.Method == http.MethodGet {
+				found = route
+				break
+			}
+		}
+		if found == nil {
+			b.Fatal("no route found")
+		}
+		found.parseProperties(path)
+	}
+}
+
+func TestRouterLookup(t *testing.T) {
+	routes := RouteCollection{
+		NewRoute(http.MethodGet, "/", nil),
+		NewRoute(http.MethodGet, "users", nil),
+		NewRoute(http.MethodGet, "users/me", nil),
+		NewRoute(http.MethodGet, "users/{id:int}", nil),
+		NewRoute(http.MethodGet, "users/{name}", nil),
+		NewRoute(http.MethodPost, "users", nil),
+		NewRoute(http.MethodGet, "commits[/{id}]", nil),
+		NewRoute(http.MethodGet, "accounts[/{id}][/{action}]", nil),
+		NewRoute(http.MethodGet, "files/{path...}", nil),
+		NewRoute(http.MethodGet, "assets[/{path...}]", nil),
+		NewRoute(http.MethodGet, "docs/{name}.{ext}", nil),
+		NewRoute(http.MethodGet, "a/b/c", nil),
+		NewRoute(http.MethodGet, "a/{x}/d", nil),
+	}
+
+	router, err := newRouter(routes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		route   string
+		fields  map[string]string
+		allowed []string
+	}{
+		{"root", "GET", "", "/", map[string]string{}, nil},
+		{"static", "GET", "users", "users", map[string]string{}, nil},
+		{"static over param", "GET", "users/me", "users/me",
+			map[string]string{}, nil},
+		{"typed param", "GET", "users/42", "users/{id:int}",
+			map[string]string{"id": "42"}, nil},
+		{"param in registration order", "GET", "users/bob",
+			"users/{name}", map[string]string{"name": "bob"}, nil},
+		{"param matches one segment", "GET", "users/bob/extra", "",
+			nil, nil},
+		{"anchored", "GET", "api/users/bob", "", nil, nil},
+		{"optional omitted", "GET", "commits", "commits[/{id}]",
+			map[string]string{"id": ""}, nil},
+		{"optional present", "GET", "commits/abc", "commits[/{id}]",
+			map[string]string{"id": "abc"}, nil},
+		{"consecutive optionals", "GET", "accounts/1/edit",
+			"accounts[/{id}][/{action}]",
+			map[string]string{"id": "1", "action": "edit"}, nil},
+		{"first of consecutive optionals", "GET", "accounts/1",
+			"accounts[/{id}][/{action}]",
+			map[string]string{"id": "1", "action": ""}, nil},
+		{"catch-all", "GET", "files/a/b/c.txt", "files/{path...}",
+			map[string]string{"path": "a/b/c.txt"}, nil},
+		{"catch-all requires a value", "GET", "files", "", nil, nil},
+		{"optional catch-all omitted", "GET", "assets",
+			"assets[/{path...}]", map[string]string{"path": ""}, nil},
+		{"optional catch-all empty", "GET", "assets/",
+			"assets[/{path...}]", map[string]string{"path": ""}, nil},
+		{"multiple params in a segment", "GET", "docs/intro.md",
+			"docs/{name}.{ext}",
+			map[string]string{"name": "intro", "ext": "md"}, nil},
+		{"static", "GET", "a/b/c", "a/b/c", map[string]string{}, nil},
+		{"backtracking", "GET", "a/b/d", "a/{x}/d",
+			map[string]string{"x": "b"}, nil},
+		{"head uses get", "HEAD", "users", "users",
+			map[string]string{}, nil},
+		{"method not allowed", "DELETE", "users", "", nil,
+			[]string{"GET", "HEAD", "OPTIONS", "POST"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			route, fields, allowed :=
+				router.lookup(test.path, test.method)
+			if test.route == "" {
+				if route != nil {
+					t.Fatalf("expected no route, got %v", route.Path)
+				}
+			} else if route == nil || route.Path != test.route {
+				t.Fatalf("expected route %v, got %v", test.route, route)
+			}
+			if test.fields != nil &&
+				!reflect.DeepEqual(fields, test.fields) {
+				t.Errorf("expected fields %v, got %v",
+					test.fields, fields)
+			}
+			if !reflect.DeepEqual(allowed, test.allowed) {
+				t.Errorf("expected allowed %v, got %v",
+					test.allowed, allowed)
+			}
+		})
+	}
+}
+
+func TestRouterMatchesIsURL(t *testing.T) {
+	routes, _ := benchmarkRoutes()
+	router, err := newRouter(routes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	paths := []string{
+		"api/v1/resource0",
+		"api/v1/resource7/12",
+		"api/v1/resource7/abc",
+		"api/v1/resource42/3/items",
+		"api/v1/resource42/3/items/x",
+		"api/v1/resource42/3/items/x/y",
+		"api/v1/resource100",
+	}
+
+	for _, path := range paths {
+		var expected *Route
+		for _, route := range routes {
+			if route.isURL(path) {
+				expected = route
+				break
+			}
+		}
+
+		route, _, _ := router.lookup(path, http.MethodGet)
+		if route != expected {
+			t.Errorf("%v: expected %v, got %v", path, expected, route)
+		}
+	}
+}
+
+func TestInvalidRoute(t *testing.T) {
+	route := NewRoute(http.MethodGet, "users/{id", nil)
+	if route.Err() == nil {
+		t.Fatal("expected an error for an invalid path")
+	}
+
+	app := &App{}
+	app.AddController(NewController().AddRoute(route))
+
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/users/1", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("expected status 500, got %v", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("expected a JSON error, got %q: %q", ct, w.Body.String())
+	}
+
+	if _, err := app.compile(); err == nil {
+		t.Error("expected the App to fail to compile")
+	}
+}
@@ -1,8 +1,16 @@
 package galago
 
 import (
+	"bytes"
 	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode"
 )
 
 // DefaultSerializer is used when no serializer is applied to the
@@ -22,10 +30,53 @@ type Serializer struct {
 	// string. If any issues are encountered, return an empty
 	// string and an error.
 	Serialize func(map[string]interface{}) (string, error)
+	// Marshal should take any value as input and serialize it to a
+	// string. If any issues are encountered, return an empty string
+	// and an error. This is optional, and when set it is used in
+	// place of Serialize so that Response Data is not limited to a
+	// map.
+	Marshal func(interface{}) (string, error)
 	// Serialize should take a string as input and serialize it to a
 	// map. If any issues are encountered, return a nil map and an
 	// error.
 	Deserialize func(string) (map[string]interface{}, error)
+	// Stream encodes response data directly to the response and
+	// decodes input data directly from the request body. This is
+	// optional, and when set it takes precedence over Serialize,
+	// Marshal and Deserialize, which are then never called, so that
+	// the data does not need to be held in memory as a string.
+	Stream StreamSerializer
+}
+
+// StreamSerializer encodes and decodes data using an io.Writer and an
+// io.Reader. See Serializer.Stream.
+type StreamSerializer interface {
+	// Encode writes the encoding of v to w.
+	Encode(w io.Writer, v interface{}) error
+	// Decode reads the encoded value from r and stores it in the
+	// value pointed to by v. If r is empty, io.EOF is returned. If r
+	// contains anything after the value, an error is returned.
+	Decode(r io.Reader, v interface{}) error
+}
+
+// serialize serializes the specified data using Marshal if it has
+// been set. Otherwise, the data must be a map so that it can be passed
+// to Serialize.
+func (serializer *Serializer) serialize(data interface{}) (string, error) {
+	if serializer.Marshal != nil {
+		return serializer.Marshal(data)
+	}
+
+	switch data := data.(type) {
+	case map[string]interface{}:
+		return serializer.Serialize(data)
+	case nil:
+		return serializer.Serialize(map[string]interface{}{})
+	}
+
+	return "", fmt.Errorf(
+		"%v serializer cannot serialize %T, expected a map",
+		serializer.ContentType, data)
 }
 
 // MakeRawData returns the Raw Data for the specified data using the
@@ -51,6 +102,14 @@ func JSONSerializer() *Serializer {
 
 			return string(encoded), nil
 		},
+		Marshal: func(data interface{}) (string, error) {
+			encoded, err := json.Marshal(data)
+			if err != nil {
+				return "", err
+			}
+
+			return string(encoded), nil
+		},
 		Deserialize: func(data string) (map[string]interface{}, error) {
 			res := map[string]interface{}{}
 			err := json.Unmarshal([]byte(data), &res)
@@ -63,6 +122,364 @@ func JSONSerializer() *Serializer {
 	}
 }
 
+// JSONStreamSerializer returns a Serializer for JSON serialization
+// that encodes response data directly to the response and decodes
+// input data directly from the request body. Each encoded response is
+// followed by a newline.
+func JSONStreamSerializer() *Serializer {
+	serializer := JSONSerializer()
+	serializer.Stream = jsonStreamSerializer{}
+
+	return serializer
+}
+
+// jsonStreamSerializer is the StreamSerializer for JSON.
+type jsonStreamSerializer struct{}
+
+// Encode writes the JSON encoding of v to w.
+func (jsonStreamSerializer) Encode(w io.Writer, v interface{}) error {
+	return json.NewEncoder(w).Encode(v)
+}
+
+// Decode reads the JSON encoded value from r and stores it in v. Like
+// json.Unmarshal, anything other than whitespace following the value
+// is an error.
+func (jsonStreamSerializer) Decode(r io.Reader, v interface{}) error {
+	dec := json.NewDecoder(r)
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+
+	if _, err := dec.Token(); err != io.EOF {
+		var syntaxErr *json.SyntaxError
+		if err == nil || errors.As(err, &syntaxErr) {
+			return errors.New("invalid data after top-level value")
+		}
+		return err
+	}
+
+	return nil
+}
+
+// DefaultXMLRoot is the name of the root element used by
+// XMLSerializer().
+const DefaultXMLRoot = "response"
+
+// XMLSerializer returns a Serializer for XML serialization, using
+// DefaultXMLRoot as the name of the root element. See
+// NewXMLSerializer for a description of the encoding.
+//
+// To make XML available through content negotiation, use
+// app.RegisterXML(root).
+func XMLSerializer() *Serializer {
+	return NewXMLSerializer(DefaultXMLRoot)
+}
+
+// NewXMLSerializer returns a Serializer for XML serialization that
+// uses the specified name for the root element.
+//
+// Maps are encoded as an element for each key, in sorted order, and
+// arrays are encoded as an <item> element for each value. Keys that
+// are not valid element names are encoded as an <entry> element with
+// a key attribute. Structs are encoded using encoding/xml, so their
+// `xml` tags apply. Input data is decoded the same way, with elements
+// that only contain text decoded as strings.
+func NewXMLSerializer(root string) *Serializer {
+	stream := xmlStreamSerializer{root: root}
+
+	return &Serializer{
+		ContentType: "application/xml",
+		Serialize: func(data map[string]interface{}) (string, error) {
+			var b strings.Builder
+			err := stream.Encode(&b, data)
+			return b.String(), err
+		},
+		Marshal: func(data interface{}) (string, error) {
+			var b strings.Builder
+			err := stream.Encode(&b, data)
+			return b.String(), err
+		},
+		Deserialize: func(data string) (map[string]interface{}, error) {
+			res := map[string]interface{}{}
+			err := stream.Decode(strings.NewReader(data), &res)
+			if err != nil {
+				return nil, err
+			}
+
+			return res, nil
+		},
+		Stream: stream,
+	}
+}
+
+// xmlStreamSerializer is the StreamSerializer for XML.
+type xmlStreamSerializer struct {
+	root string
+}
+
+// Encode writes the XML encoding of v to w.
+func (s xmlStreamSerializer) Encode(w io.Writer, v interface{}) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(w)
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+
+	var err error
+	switch {
+	case rv.Kind() == reflect.Struct:
+		err = enc.Encode(v)
+	case isXMLList(rv):
+		err = encodeXMLSlice(enc, xmlStart(s.root, false), rv)
+	default:
+		err = encodeXMLValue(enc, xmlStart(s.root, false), v)
+	}
+	if err != nil {
+		return err
+	}
+
+	return enc.Flush()
+}
+
+// Decode reads the XML encoded value from r and stores it in v. If v
+// is a pointer to a map[string]interface{}, the elements within the
+// root element are decoded into it. Otherwise, encoding/xml is used.
+// Anything other than whitespace, comments and processing
+// instructions following the root element is an error.
+func (s xmlStreamSerializer) Decode(r io.Reader, v interface{}) error {
+	dec := xml.NewDecoder(r)
+	m, isMap := v.(*map[string]interface{})
+	if !isMap {
+		if err := dec.Decode(v); err != nil {
+			return err
+		}
+		return xmlEnd(dec)
+	}
+
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+
+		if start, isStart := tok.(xml.StartElement); isStart {
+			value, err := decodeXMLElement(dec, start)
+			if err != nil {
+				return err
+			}
+
+			if data, isData := value.(map[string]interface{}); isData {
+				*m = data
+			} else {
+				*m = map[string]interface{}{}
+			}
+			return xmlEnd(dec)
+		}
+	}
+}
+
+// xmlEnd reads the remainder of a document after its root element,
+// returning an error if it contains anything other than whitespace,
+// comments and processing instructions.
+func xmlEnd(dec *xml.Decoder) error {
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch tok := tok.(type) {
+		case xml.Comment, xml.ProcInst:
+		case xml.CharData:
+			if len(bytes.TrimSpace(tok)) > 0 {
+				return errors.New("invalid data after root element")
+			}
+		default:
+			return errors.New("invalid data after root element")
+		}
+	}
+}
+
+// xmlStart returns the start of the element encoding a value with the
+// specified name. Names that are not valid element names are encoded
+// as an <entry> element with a key attribute, as are map keys named
+// `item`, so that they are not decoded as a list.
+func xmlStart(name string, isKey bool) xml.StartElement {
+	if isXMLName(name) && !(isKey && name == "item") {
+		return xml.StartElement{Name: xml.Name{Local: name}}
+	}
+
+	return xml.StartElement{
+		Name: xml.Name{Local: "entry"},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "key"}, Value: name}},
+	}
+}
+
+// encodeXMLValue encodes v as the element started by start.
+func encodeXMLValue(
+	enc *xml.Encoder, start xml.StartElement, v interface{},
+) error {
+	switch v := v.(type) {
+	case nil:
+		if err := enc.EncodeToken(start); err != nil {
+			return err
+		}
+		return enc.EncodeToken(start.End())
+
+	case map[string]interface{}:
+		if err := enc.EncodeToken(start); err != nil {
+			return err
+		}
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			err := encodeXMLValue(enc, xmlStart(key, true), v[key])
+			if err != nil {
+				return err
+			}
+		}
+		return enc.EncodeToken(start.End())
+
+	case []interface{}:
+		return encodeXMLSlice(enc, start, reflect.ValueOf(v))
+	}
+
+	if rv := reflect.ValueOf(v); isXMLList(rv) {
+		return encodeXMLSlice(enc, start, rv)
+	}
+
+	return enc.EncodeElement(v, start)
+}
+
+// encodeXMLSlice encodes the slice or array rv as the element started
+// by start, containing an <item> element for each value.
+func encodeXMLSlice(
+	enc *xml.Encoder, start xml.StartElement, rv reflect.Value,
+) error {
+	if err := enc.EncodeToken(start); err != nil {
+		return err
+	}
+
+	item := xmlStart("item", false)
+	for i := 0; i < rv.Len(); i++ {
+		err := encodeXMLValue(enc, item, rv.Index(i).Interface())
+		if err != nil {
+			return err
+		}
+	}
+
+	return enc.EncodeToken(start.End())
+}
+
+// decodeXMLElement decodes the contents of the element that was
+// started by start. Elements containing other elements are decoded
+// into a map, or a slice if they only contain <item> elements, and
+// all other elements are decoded into their text. A map key named
+// `item` is written as `<entry key="item">`, so it is not mistaken
+// for a list.
+func decodeXMLElement(
+	dec *xml.Decoder, start xml.StartElement,
+) (interface{}, error) {
+	var text strings.Builder
+	keys := []string{}
+	values := map[string][]interface{}{}
+	onlyItems := true
+
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		switch tok := tok.(type) {
+		case xml.CharData:
+			text.Write(tok)
+
+		case xml.StartElement:
+			key := tok.Name.Local
+			if key == "entry" {
+				for _, attr := range tok.Attr {
+					if attr.Name.Local == "key" {
+						key = attr.Value
+					}
+				}
+			}
+			if tok.Name.Local != "item" {
+				onlyItems = false
+			}
+
+			value, err := decodeXMLElement(dec, tok)
+			if err != nil {
+				return nil, err
+			}
+			if _, exists := values[key]; !exists {
+				keys = append(keys, key)
+			}
+			values[key] = append(values[key], value)
+
+		case xml.EndElement:
+			if len(keys) == 0 {
+				return text.String(), nil
+			}
+			if onlyItems {
+				return values["item"], nil
+			}
+
+			res := make(map[string]interface{}, len(keys))
+			for _, key := range keys {
+				if len(values[key]) == 1 {
+					res[key] = values[key][0]
+				} else {
+					res[key] = values[key]
+				}
+			}
+			return res, nil
+		}
+	}
+}
+
+// isXMLList reports whether rv is a slice or array that is encoded as
+// a list of <item> elements. Byte slices are encoded as text.
+func isXMLList(rv reflect.Value) bool {
+	switch rv.Kind() {
+	case reflect.Slice:
+		return rv.Type().Elem().Kind() != reflect.Uint8
+	case reflect.Array:
+		return true
+	}
+
+	return false
+}
+
+// isXMLName reports whether the name can be used as the name of an
+// XML element.
+func isXMLName(name string) bool {
+	if name == "" || strings.HasPrefix(strings.ToLower(name), "xml") {
+		return false
+	}
+
+	for i, c := range name {
+		switch {
+		case c == '_' || unicode.IsLetter(c):
+		case i > 0 && (c == '-' || c == '.' || unicode.IsDigit(c)):
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
 // DownloadSerializer returns a Serializer for file downloads.
 func DownloadSerializer() *Serializer {
 	return NewRawSerializer("data", "application/octet-stream")
@@ -76,16 +493,32 @@ func TextSerializer() *Serializer {
 // NewRawSerializer creates a serializer that takes raw input and
 // applies the specified content type to responses that are serialized
 // using it. When serializing data with this serializer, you should
-// use a map with one entry being the data to serialize mapped to the
-// key specified in key.
+// either use a string or []byte, or a map with one entry being the
+// data to serialize mapped to the key specified in key.
 func NewRawSerializer(key string, contentType string) *Serializer {
+	serialize := func(data map[string]interface{}) (string, error) {
+		if out, exists := data[key]; exists {
+			if out, isString := out.(string); isString {
+				return out, nil
+			}
+		}
+
+		return "", fmt.Errorf("invalid %s data", key)
+	}
+
 	return &Serializer{
 		IsRaw:       true,
 		Key:         key,
 		ContentType: contentType,
-		Serialize: func(data map[string]interface{}) (string, error) {
-			if out, exists := data[key]; exists {
-				return out.(string), nil
+		Serialize:   serialize,
+		Marshal: func(data interface{}) (string, error) {
+			switch data := data.(type) {
+			case string:
+				return data, nil
+			case []byte:
+				return string(data), nil
+			case map[string]interface{}:
+				return serialize(data)
 			}
 
 			return "", fmt.Errorf("invalid %s data", key)
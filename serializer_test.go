@@ -0,0 +1,171 @@
+package galago
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestJSONDecodeTrailingData(t *testing.T) {
+	stream := JSONStreamSerializer().Stream
+
+	valid := []string{`{"page":2}`, "{\"page\":2}\n", ` {"page":2} `}
+	for _, body := range valid {
+		data := map[string]interface{}{}
+		if err := stream.Decode(strings.NewReader(body), &data); err != nil {
+			t.Errorf("%q: unexpected error: %v", body, err)
+		}
+	}
+
+	invalid := []string{
+		`{"page":2} trailing garbage`,
+		`{"page":2}{"page":3}`,
+		`{"page":2}}`,
+	}
+	for _, body := range invalid {
+		data := map[string]interface{}{}
+		if err := stream.Decode(strings.NewReader(body), &data); err == nil {
+			t.Errorf("%q: expected an error", body)
+		}
+	}
+}
+
+func TestReadInputTrailingData(t *testing.T) {
+	for _, serializer := range []*Serializer{
+		JSONSerializer(), JSONStreamSerializer(),
+	} {
+		route := NewRoute(http.MethodPost, "items", func(Request) *Response {
+			return NewResponse(http.StatusOK, nil)
+		})
+		route.Serializer = serializer
+
+		app := &App{}
+		app.AddController(NewController().AddRoute(route))
+
+		r := httptest.NewRequest(http.MethodPost, "/items",
+			strings.NewReader(`{"page":2} trailing garbage`))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, r)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("stream %v: expected status 400, got %v: %v",
+				serializer.Stream != nil, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestJSONSerializerStreamPrecedence(t *testing.T) {
+	customize := func(serializer *Serializer) *Serializer {
+		serializer.Deserialize = func(string) (map[string]interface{}, error) {
+			return map[string]interface{}{"value": "custom"}, nil
+		}
+		return serializer
+	}
+
+	tests := []struct {
+		name       string
+		serializer *Serializer
+		output     string
+	}{
+		{"default", JSONSerializer(), `{"value":"value"}`},
+		{"custom", customize(JSONSerializer()), `{"value":"custom"}`},
+		{"stream", JSONStreamSerializer(), "{\"value\":\"value\"}\n"},
+		{"custom stream", customize(JSONStreamSerializer()),
+			"{\"value\":\"value\"}\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			route := NewRoute(http.MethodPost, "items",
+				func(request Request) *Response {
+					return NewResponse(http.StatusOK, request.Data)
+				})
+			route.Serializer = test.serializer
+
+			app := &App{}
+			app.AddController(NewController().AddRoute(route))
+
+			r := httptest.NewRequest(http.MethodPost, "/items",
+				strings.NewReader(`{"value":"value"}`))
+			r.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			app.ServeHTTP(w, r)
+
+			if w.Body.String() != test.output {
+				t.Errorf("expected %q, got %q", test.output, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestXMLRoundTrip(t *testing.T) {
+	serializer := XMLSerializer()
+
+	data := map[string]interface{}{
+		"a":    map[string]interface{}{"item": "x"},
+		"list": []interface{}{"1", "2"},
+		"one":  []interface{}{"1"},
+		"nested": map[string]interface{}{
+			"item":  []interface{}{"y", "z"},
+			"other": "w",
+		},
+		"bad key": "v",
+	}
+
+	encoded, err := serializer.Serialize(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := serializer.Deserialize(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(decoded, data) {
+		t.Errorf("expected %v, got %v from %v", data, decoded, encoded)
+	}
+}
+
+func TestXMLDecodeTrailingData(t *testing.T) {
+	serializer := XMLSerializer()
+
+	valid := "<response><a>b</a></response>\n<!-- end -->\n"
+	if _, err := serializer.Deserialize(valid); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	invalid := "<response><a>b</a></response>trailing"
+	if _, err := serializer.Deserialize(invalid); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestRegisterXML(t *testing.T) {
+	route := NewRoute(http.MethodGet, "items", func(Request) *Response {
+		return NewResponse(http.StatusOK, map[string]interface{}{
+			"name": "value",
+		})
+	})
+
+	app := &App{}
+	app.RegisterXML("items")
+	app.AddController(NewController().AddRoute(route))
+
+	for _, mediaType := range []string{"application/xml", "text/xml"} {
+		r := httptest.NewRequest(http.MethodGet, "/items", nil)
+		r.Header.Set("Accept", mediaType)
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, r)
+
+		if ct := w.Header().Get("Content-Type"); ct != mediaType {
+			t.Errorf("expected %v, got %v", mediaType, ct)
+		}
+		if !strings.Contains(w.Body.String(), "<items><name>value</name>") {
+			t.Errorf("unexpected body %q", w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package galago
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// DefaultDrainTimeout is the amount of time to wait for in-flight
+// requests to complete during shutdown when no DrainTimeout has been
+// configured on the App.
+const DefaultDrainTimeout = 30 * time.Second
+
+// lifecycle holds the state of an App while it is listening. The
+// servers and stopping fields are guarded by the mutex of the App.
+type lifecycle struct {
+	servers  []*http.Server
+	stopping bool
+	once     sync.Once
+	done     chan struct{}
+	err      error
+}
+
+// OnStart adds a hook that is called once the App has started
+// listening for requests. If a hook returns an error, the App is shut
+// down and the error is returned from Listen or ListenContext.
+func (app *App) OnStart(hook func() error) *App {
+	app.startHooks = append(app.startHooks, hook)
+	return app
+}
+
+// OnShutdown adds a hook that is called once the App has stopped
+// listening and all in-flight requests have completed, or the drain
+// timeout has been reached. The context passed to the hook is done
+// when the drain timeout is reached.
+func (app *App) OnShutdown(hook func(context.Context) error) *App {
+	app.shutdownHooks = append(app.shutdownHooks, hook)
+	return app
+}
+
+// Listen will start listening for HTTP and HTTPS requests sent to the
+// application and process them respectively. When the process
+// receives SIGINT or SIGTERM, the App is shut down gracefully. Listen
+// returns once the App has shut down.
+func (app *App) Listen() error {
+	ctx, stop := signal.NotifyContext(
+		context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	return app.ListenContext(ctx)
+}
+
+// ListenContext will start listening for HTTP and HTTPS requests sent
+// to the application and process them respectively. When ctx is done,
+// the App is shut down gracefully, waiting up to DrainTimeout for
+// in-flight requests to complete. ListenContext returns once the App
+// has shut down, along with any error that caused it to stop.
+func (app *App) ListenContext(ctx context.Context) error {
+	if _, err := app.compile(); err != nil {
+		return err
+	}
+
+	if len(app.getRoutes()) < 1 {
+		if logger != nil {
+			logger.Print("warning : no routes defined")
+		}
+	}
+
+	if logger != nil {
+		for _, route := range app.getRoutes() {
+			logger.Printf(
+				"initialize : loaded route %v %p\n",
+				route.Path, route.Handler)
+		}
+	}
+
+	run := &lifecycle{done: make(chan struct{})}
+
+	app.mu.Lock()
+	if app.running != nil {
+		app.mu.Unlock()
+		return errors.New("app is already listening")
+	}
+	app.running = run
+	app.mu.Unlock()
+
+	defer func() {
+		app.mu.Lock()
+		app.running = nil
+		app.mu.Unlock()
+	}()
+
+	type binding struct {
+		server *http.Server
+		ln     net.Listener
+		tls    bool
+	}
+	bindings := []binding{}
+	servers := []*http.Server{}
+
+	closeListeners := func() {
+		for _, b := range bindings {
+			b.ln.Close()
+		}
+	}
+
+	bind := func(addr string, tls bool) error {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			closeListeners()
+			return err
+		}
+
+		template := app.HTTPServer
+		if tls {
+			template = app.HTTPSServer
+		}
+
+		server := app.newServer(addr, template)
+		bindings = append(bindings, binding{server, ln, tls})
+		servers = append(servers, server)
+		return nil
+	}
+
+	if ModeHTTP&app.Mode == ModeHTTP {
+		if err := bind(app.Address, false); err != nil {
+			return err
+		}
+		if logger != nil {
+			logger.Printf(
+				"initialize : http starting at %s\n", app.Address)
+		}
+	}
+
+	if ModeHTTPS&app.Mode == ModeHTTPS {
+		if err := bind(app.TLSAddress, true); err != nil {
+			return err
+		}
+		if logger != nil {
+			logger.Printf(
+				"initialize : https starting at %s\n", app.TLSAddress)
+		}
+	}
+
+	app.mu.Lock()
+	if run.stopping {
+		app.mu.Unlock()
+		closeListeners()
+		return app.Shutdown(context.Background())
+	}
+	run.servers = servers
+	app.mu.Unlock()
+
+	errs := make(chan error, len(bindings))
+	for _, b := range bindings {
+		go func(b binding) {
+			if b.tls {
+				errs <- b.server.ServeTLS(
+					b.ln, app.TLSCertFile, app.TLSKeyFile)
+			} else {
+				errs <- b.server.Serve(b.ln)
+			}
+		}(b)
+	}
+
+	var err error
+	for _, hook := range app.startHooks {
+		if err = hook(); err != nil {
+			break
+		}
+	}
+
+	if err == nil && len(servers) > 0 {
+		select {
+		case <-ctx.Done():
+		case err = <-errs:
+			if errors.Is(err, http.ErrServerClosed) {
+				err = nil
+			}
+		}
+	}
+
+	timeout := app.DrainTimeout
+	if timeout <= 0 {
+		timeout = DefaultDrainTimeout
+	}
+	drainCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if shutdownErr := app.Shutdown(drainCtx); err == nil {
+		err = shutdownErr
+	}
+
+	return err
+}
+
+// Shutdown gracefully shuts down the HTTP and HTTPS servers of the App
+// without interrupting any active connections, waiting until either
+// all in-flight requests have completed or ctx is done. Open WebSockets
+// are then closed with CloseGoingAway. Once the servers have stopped,
+// the hooks added with OnShutdown are called.
+//
+// If the App is not listening, Shutdown does nothing. Calling
+// Shutdown causes Listen and ListenContext to return.
+func (app *App) Shutdown(ctx context.Context) error {
+	app.mu.Lock()
+	run := app.running
+	var servers []*http.Server
+	if run != nil {
+		run.stopping = true
+		servers = run.servers
+	}
+	app.mu.Unlock()
+
+	if run == nil {
+		return nil
+	}
+
+	run.once.Do(func() {
+		go func() {
+			defer close(run.done)
+			run.err = app.shutdown(ctx, servers)
+		}()
+	})
+
+	select {
+	case <-run.done:
+		return run.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// shutdown stops the specified servers and runs the shutdown hooks,
+// returning the first error encountered.
+func (app *App) shutdown(ctx context.Context, servers []*http.Server) error {
+	if logger != nil {
+		logger.Print("shutdown : draining in-flight requests\n")
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(servers))
+	for i, server := range servers {
+		wg.Add(1)
+		go func(i int, server *http.Server) {
+			defer wg.Done()
+			errs[i] = server.Shutdown(ctx)
+		}(i, server)
+	}
+	wg.Wait()
+
+	// WebSockets are not closed by the servers, since their
+	// connections have been hijacked.
+	app.closeWebSockets(ctx)
+
+	for _, hook := range app.shutdownHooks {
+		errs = append(errs, hook(ctx))
+	}
+
+	if logger != nil {
+		logger.Print("shutdown : complete\n")
+	}
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// newServer creates the http.Server used to serve the App at the
+// specified address, using the settings from the template if it is
+// not nil. If the template has no ErrorLog, the galago logger is used.
+func (app *App) newServer(addr string, template *http.Server) *http.Server {
+	server := &http.Server{Addr: addr, Handler: app, ErrorLog: logger}
+	if template == nil {
+		return server
+	}
+
+	server.TLSConfig = template.TLSConfig
+	server.ReadTimeout = template.ReadTimeout
+	server.ReadHeaderTimeout = template.ReadHeaderTimeout
+	server.WriteTimeout = template.WriteTimeout
+	server.IdleTimeout = template.IdleTimeout
+	server.MaxHeaderBytes = template.MaxHeaderBytes
+	server.TLSNextProto = template.TLSNextProto
+	server.ConnState = template.ConnState
+	server.BaseContext = template.BaseContext
+	server.ConnContext = template.ConnContext
+	if template.ErrorLog != nil {
+		server.ErrorLog = template.ErrorLog
+	}
+
+	return server
+}
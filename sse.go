@@ -0,0 +1,192 @@
+package galago
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultSSEHeartbeat is the interval at which heartbeats are sent on
+// an EventStream when no interval has been configured on the App.
+const DefaultSSEHeartbeat = 15 * time.Second
+
+// EventStream sends Server-Sent Events to a client. It is safe for
+// concurrent use.
+type EventStream struct {
+	stream      *Stream
+	lastEventID string
+	mu          sync.Mutex
+}
+
+// SSE creates a streaming Response that sends Server-Sent Events to
+// the client by calling fn with an EventStream. The stream remains
+// open until fn returns.
+//
+// While the stream is open, a comment is sent as a heartbeat at the
+// interval configured in the SSEHeartbeat of the App, so that proxies
+// do not close an idle connection. Once the client disconnects, the
+// context of the EventStream is done and any further events fail to
+// send.
+func (request *Request) SSE(fn func(events *EventStream) error) *Response {
+	interval := DefaultSSEHeartbeat
+	if request.app != nil && request.app.SSEHeartbeat != 0 {
+		interval = request.app.SSEHeartbeat
+	}
+
+	lastEventID := ""
+	if request.HTTPRequest != nil {
+		lastEventID = request.HTTPRequest.Header.Get("Last-Event-ID")
+	}
+
+	response := NewStreamResponse(http.StatusOK, func(stream *Stream) error {
+		events := &EventStream{stream: stream, lastEventID: lastEventID}
+
+		// Send the headers right away so that the client knows the
+		// stream is open before the first event.
+		stream.Flush()
+
+		done := make(chan struct{})
+		var wg sync.WaitGroup
+		if interval > 0 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				events.heartbeat(interval, done)
+			}()
+		}
+
+		err := fn(events)
+		close(done)
+		wg.Wait()
+
+		// A client that disconnects is the normal end of a stream.
+		if err != nil && stream.Context().Err() != nil {
+			return nil
+		}
+
+		return err
+	})
+
+	response.SetHeader("Content-Type", "text/event-stream")
+	response.SetHeader("Cache-Control", "no-cache")
+	response.SetHeader("X-Accel-Buffering", "no")
+
+	return response
+}
+
+// LastEventID returns the value of the Last-Event-ID header sent by a
+// client that is reconnecting to the stream, so that it can be resumed
+// after the last event the client received. It is empty if the client
+// is not reconnecting.
+func (events *EventStream) LastEventID() string {
+	return events.lastEventID
+}
+
+// Context returns the context of the request, which is done once the
+// client disconnects.
+func (events *EventStream) Context() context.Context {
+	return events.stream.Context()
+}
+
+// Send sends an event to the client. The event and id are optional
+// and are omitted when empty. Data that is a string or []byte is sent
+// as is, and any other value is encoded as JSON. Data spanning several
+// lines is sent with one data field per line, which the client joins
+// back together with LF line endings.
+func (events *EventStream) Send(event, id string, data interface{}) error {
+	var text string
+	switch data := data.(type) {
+	case string:
+		text = data
+	case []byte:
+		text = string(data)
+	default:
+		encoded, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		text = string(encoded)
+	}
+
+	var b strings.Builder
+	if event != "" {
+		fmt.Fprintf(&b, "event: %s\n", sanitizeSSE(event))
+	}
+	if id != "" {
+		fmt.Fprintf(&b, "id: %s\n", sanitizeSSE(id))
+	}
+	for _, line := range splitSSELines(text) {
+		fmt.Fprintf(&b, "data: %s\n", line)
+	}
+	b.WriteString("\n")
+
+	return events.write(b.String())
+}
+
+// Retry tells the client how long to wait before reconnecting if the
+// connection is lost.
+func (events *EventStream) Retry(d time.Duration) error {
+	return events.write(fmt.Sprintf("retry: %d\n\n", d.Milliseconds()))
+}
+
+// Comment sends a comment to the client, which is ignored by the
+// client.
+func (events *EventStream) Comment(text string) error {
+	return events.write(fmt.Sprintf(": %s\n\n", sanitizeSSE(text)))
+}
+
+// write writes the text to the client and flushes it.
+func (events *EventStream) write(text string) error {
+	events.mu.Lock()
+	defer events.mu.Unlock()
+
+	if _, err := io.WriteString(events.stream, text); err != nil {
+		return err
+	}
+	events.stream.Flush()
+
+	return nil
+}
+
+// heartbeat sends a comment at the specified interval until done is
+// closed or the client disconnects.
+func (events *EventStream) heartbeat(
+	interval time.Duration, done <-chan struct{},
+) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-events.Context().Done():
+			return
+		case <-ticker.C:
+			if err := events.Comment("heartbeat"); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// splitSSELines splits the text into lines on each CRLF, CR or LF,
+// the line endings recognized by clients, so that every line of the
+// data is sent in its own data field.
+func splitSSELines(text string) []string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+
+	return strings.Split(text, "\n")
+}
+
+// sanitizeSSE removes line breaks, which would end the field they are
+// written in.
+func sanitizeSSE(s string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+}
@@ -0,0 +1,226 @@
+package galago
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventStreamSend(t *testing.T) {
+	tests := []struct {
+		name     string
+		event    string
+		id       string
+		data     interface{}
+		expected string
+	}{
+		{"data only", "", "", "hello", "data: hello\n\n"},
+		{"event and id", "update", "7", "hello",
+			"event: update\nid: 7\ndata: hello\n\n"},
+		{"bytes", "", "", []byte("hello"), "data: hello\n\n"},
+		{"json", "", "", map[string]int{"n": 1}, "data: {\"n\":1}\n\n"},
+		{"empty", "", "", "", "data: \n\n"},
+		{"lf", "", "", "a\nb", "data: a\ndata: b\n\n"},
+		{"crlf", "", "", "a\r\nb", "data: a\ndata: b\n\n"},
+		{"cr", "", "", "a\rid: evil", "data: a\ndata: id: evil\n\n"},
+		{"mixed", "", "", "a\r\rb\r\n\nc\n",
+			"data: a\ndata: \ndata: b\ndata: \ndata: c\ndata: \n\n"},
+		{"line breaks in event and id", "up\rdate", "1\r\n2", "x",
+			"event: update\nid: 12\ndata: x\n\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			events := &EventStream{
+				stream: &Stream{w: &buf, ctx: context.Background()},
+			}
+
+			if err := events.Send(test.event, test.id, test.data); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if buf.String() != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, buf.String())
+			}
+		})
+	}
+}
+
+func TestStreamResponse(t *testing.T) {
+	tests := []struct {
+		name    string
+		fn      StreamFunc
+		status  int
+		body    string
+		aborted bool
+	}{
+		{"complete", func(stream *Stream) error {
+			io.WriteString(stream, "a\n")
+			stream.Flush()
+			io.WriteString(stream, "b\n")
+			return nil
+		}, http.StatusCreated, "a\nb\n", false},
+		{"error before writing", func(*Stream) error {
+			return errors.New("failed")
+		}, http.StatusInternalServerError, "", false},
+		{"panic before writing", func(*Stream) error {
+			panic("failed")
+		}, http.StatusInternalServerError, "", false},
+		{"error after writing", func(stream *Stream) error {
+			io.WriteString(stream, "a\n")
+			return errors.New("failed")
+		}, http.StatusCreated, "a\n", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			app := &App{}
+			app.AddController(NewController().AddRoute(
+				NewRoute(http.MethodGet, "stream", func(Request) *Response {
+					return NewStreamResponse(http.StatusCreated, test.fn).
+						SetHeader("Content-Type", "text/plain")
+				})))
+
+			w := httptest.NewRecorder()
+			aborted := func() (aborted bool) {
+				defer func() {
+					if recovered := recover(); recovered != nil {
+						if recovered != http.ErrAbortHandler {
+							panic(recovered)
+						}
+						aborted = true
+					}
+				}()
+				app.ServeHTTP(w, httptest.NewRequest(
+					http.MethodGet, "/stream", nil))
+				return false
+			}()
+
+			if w.Code != test.status {
+				t.Errorf("expected status %v, got %v", test.status, w.Code)
+			}
+			if aborted != test.aborted {
+				t.Errorf("expected aborted %v, got %v", test.aborted, aborted)
+			}
+			if test.status != http.StatusInternalServerError {
+				if w.Body.String() != test.body {
+					t.Errorf("expected %q, got %q", test.body, w.Body.String())
+				}
+				contentType := w.Header().Get("Content-Type")
+				if contentType != "text/plain" {
+					t.Errorf("expected text/plain, got %v", contentType)
+				}
+			}
+		})
+	}
+}
+
+func TestSSE(t *testing.T) {
+	app := &App{SSEHeartbeat: 5 * time.Millisecond}
+	app.AddController(NewController().AddRoute(
+		NewRoute(http.MethodGet, "events", func(request Request) *Response {
+			return request.SSE(func(events *EventStream) error {
+				if err := events.Send("", "", events.LastEventID()); err != nil {
+					return err
+				}
+				time.Sleep(50 * time.Millisecond)
+				return events.Send("", "", "done")
+			})
+		})))
+
+	r := httptest.NewRequest(http.MethodGet, "/events", nil)
+	r.Header.Set("Last-Event-ID", "41")
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status 200, got %v", w.Code)
+	}
+	headers := map[string]string{
+		"Content-Type":      "text/event-stream",
+		"Cache-Control":     "no-cache",
+		"X-Accel-Buffering": "no",
+	}
+	for name, expected := range headers {
+		if value := w.Header().Get(name); value != expected {
+			t.Errorf("expected %v %q, got %q", name, expected, value)
+		}
+	}
+
+	body := w.Body.String()
+	if !strings.HasPrefix(body, "data: 41\n\n") {
+		t.Errorf("expected the Last-Event-ID to be sent first, got %q", body)
+	}
+	if !strings.Contains(body, "\ndata: done\n\n") {
+		t.Errorf("expected the last event to be sent, got %q", body)
+	}
+	if !strings.Contains(body, ": heartbeat\n\n") {
+		t.Errorf("expected a heartbeat, got %q", body)
+	}
+}
+
+func TestSSEHeartbeatDisabled(t *testing.T) {
+	app := &App{SSEHeartbeat: -1}
+	app.AddController(NewController().AddRoute(
+		NewRoute(http.MethodGet, "events", func(request Request) *Response {
+			return request.SSE(func(events *EventStream) error {
+				time.Sleep(20 * time.Millisecond)
+				return events.Send("", "", "done")
+			})
+		})))
+
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/events", nil))
+
+	if body := w.Body.String(); body != "data: done\n\n" {
+		t.Errorf("expected only the event, got %q", body)
+	}
+}
+
+func TestSSEClientDisconnect(t *testing.T) {
+	returned := make(chan error, 1)
+
+	app := &App{}
+	app.AddController(NewController().AddRoute(
+		NewRoute(http.MethodGet, "events", func(request Request) *Response {
+			return request.SSE(func(events *EventStream) error {
+				<-events.Context().Done()
+				err := events.Send("", "", "gone")
+				returned <- err
+				return err
+			})
+		})))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := httptest.NewRequest(http.MethodGet, "/events", nil).WithContext(ctx)
+	w := httptest.NewRecorder()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		app.ServeHTTP(w, r)
+	}()
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected the stream to end once the client disconnected")
+	}
+
+	if err := <-returned; err == nil {
+		t.Error("expected sending after a disconnect to fail")
+	}
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status 200, got %v", w.Code)
+	}
+	if strings.Contains(w.Body.String(), "gone") {
+		t.Errorf("expected no event after a disconnect, got %q", w.Body)
+	}
+}
@@ -0,0 +1,419 @@
+package galago
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultStaticIndex is the name of the file served for a directory
+// when no index has been configured in the StaticOptions.
+const DefaultStaticIndex = "index.html"
+
+// StaticOptions configures how a Route created with NewStaticRoute
+// serves files.
+type StaticOptions struct {
+	// The name of the file served for a directory. Defaults to
+	// DefaultStaticIndex.
+	Index string
+	// Whether or not a listing of the files in a directory is served
+	// when the directory has no index file. Otherwise, a 404 Not Found
+	// is sent.
+	Browse bool
+	// Whether or not the Route serves a single-page app. When set, the
+	// index file at the root is sent for any request that accepts HTML
+	// and does not match a file, so that the app can handle its own
+	// routing. HTML files are sent with `Cache-Control: no-cache` so
+	// that they are revalidated, and files with a hash of their
+	// content in their name, such as `app.3f9a2b1c.js`, are cached for
+	// a year.
+	SPA bool
+	// Paths under the prefix of the Route for which the index file is
+	// never sent in place of a missing file, such as `api`. A path is
+	// excluded along with everything below it.
+	Exclude []string
+	// Matches the names of the files that have a hash of their
+	// content in their name, which are cached for a year when SPA is
+	// set. The expression is matched against the name of the file
+	// without its directory. Defaults to names ending in a hash of at
+	// least 8 hexadecimal digits, separated from the rest of the name
+	// by a `.` or a `-`, such as `app.3f9a2b1c.js`.
+	HashedAssets *regexp.Regexp
+}
+
+// Cache-Control headers sent by a Route serving a single-page app.
+const (
+	cacheRevalidate = "no-cache"
+	cacheImmutable  = "public, max-age=31536000, immutable"
+)
+
+// staticFiles serves the files of a static Route.
+type staticFiles struct {
+	fsys    fs.FS
+	options StaticOptions
+	// The ETags of files without a modification time, such as those
+	// in an embed.FS, keyed by the name of the file.
+	etags sync.Map
+}
+
+// fileContent is a file sent as the body of a Response.
+type fileContent struct {
+	io.ReadSeeker
+	name    string
+	modTime time.Time
+	file    fs.File
+}
+
+// NewStaticRoute creates a new Route that serves the files in fsys
+// under the specified prefix. For example, with the prefix `assets`,
+// a request for `assets/css/site.css` is answered with the file
+// `css/site.css`. Any fs.FS can be used, including an embed.FS.
+//
+// The Content-Type of each file is determined from its extension, or
+// from its content if the extension is unknown. Range requests and
+// conditional requests using If-Modified-Since and If-None-Match are
+// answered using http.ServeContent. Requests for paths containing
+// `..` elements receive a 404 Not Found.
+//
+// The Route is a fallback, so it is only used for GET and HEAD
+// requests that do not match any other Route in the App. Requests
+// using other methods, and requests for paths that other Routes
+// answer with a 405 Method Not Allowed, are never passed to it.
+//
+// Only the first StaticOptions are used. Without any, the index file
+// of a directory is served and directories are not listed.
+func NewStaticRoute(
+	prefix string, fsys fs.FS, options ...StaticOptions,
+) *Route {
+	static := &staticFiles{fsys: fsys}
+	if len(options) > 0 {
+		static.options = options[0]
+	}
+	if static.options.Index == "" {
+		static.options.Index = DefaultStaticIndex
+	}
+
+	path := "[/{path...}]"
+	if prefix = strings.Trim(prefix, "/"); prefix != "" {
+		path = prefix + path
+	}
+
+	route := NewRoute(http.MethodGet, path, static.handle)
+	route.fallback = true
+
+	return route
+}
+
+// Static creates a new Route that serves the files in the specified
+// directory under the prefix, and adds it to the App in a new
+// Controller. The Route is returned so that Middleware and rate
+// limits can be applied to it. See NewStaticRoute.
+func (app *App) Static(
+	prefix, dir string, options ...StaticOptions,
+) *Route {
+	route := NewStaticRoute(prefix, os.DirFS(dir), options...)
+	app.AddController(NewController().AddRoute(route))
+
+	return route
+}
+
+// handle serves the file or directory matching the Request.
+func (static *staticFiles) handle(request Request) *Response {
+	name := request.fields["path"]
+	isDir := name == "" || strings.HasSuffix(name, "/")
+
+	name = strings.TrimSuffix(name, "/")
+	if name == "" {
+		name = "."
+	}
+	if !fs.ValidPath(name) || strings.Contains(name, `\`) {
+		return notFound()
+	}
+
+	info, err := fs.Stat(static.fsys, name)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return static.missing(request, name)
+		}
+		return openError(err)
+	}
+
+	if !info.IsDir() {
+		if isDir {
+			return static.missing(request, name)
+		}
+		return static.file(name, info)
+	}
+
+	// Directories are redirected to a path with a trailing slash so
+	// that relative links within them resolve correctly.
+	if !isDir {
+		u := *request.HTTPRequest.URL
+		u.Path += "/"
+		return request.Redirect(u.RequestURI(), http.StatusMovedPermanently)
+	}
+
+	index := path.Join(name, static.options.Index)
+	if info, err := fs.Stat(static.fsys, index); err == nil && !info.IsDir() {
+		return static.file(index, info)
+	}
+
+	if static.options.Browse {
+		return static.listing(name)
+	}
+
+	return static.missing(request, name)
+}
+
+// missing returns the Response for a request that does not match a
+// file. For a single-page app, the index file at the root is sent to
+// requests that accept HTML unless the path is excluded. Otherwise, a
+// 404 Not Found is sent.
+func (static *staticFiles) missing(request Request, name string) *Response {
+	if !static.options.SPA || !acceptsHTML(request.HTTPRequest) {
+		return notFound()
+	}
+
+	for _, exclude := range static.options.Exclude {
+		exclude = strings.Trim(exclude, "/")
+		if name == exclude || strings.HasPrefix(name, exclude+"/") {
+			return notFound()
+		}
+	}
+
+	info, err := fs.Stat(static.fsys, static.options.Index)
+	if err != nil || info.IsDir() {
+		return notFound()
+	}
+
+	return static.file(static.options.Index, info)
+}
+
+// file returns a Response sending the specified file.
+func (static *staticFiles) file(name string, info fs.FileInfo) *Response {
+	file, err := static.fsys.Open(name)
+	if err != nil {
+		return openError(err)
+	}
+
+	content, isSeeker := file.(io.ReadSeeker)
+	if !isSeeker {
+		data, err := io.ReadAll(file)
+		if err != nil {
+			file.Close()
+			return openError(err)
+		}
+		content = bytes.NewReader(data)
+	}
+
+	etag, err := static.etag(name, info, content)
+	if err != nil {
+		file.Close()
+		return openError(err)
+	}
+
+	response := NewResponse(http.StatusOK, nil)
+	response.content = &fileContent{
+		ReadSeeker: content,
+		name:       info.Name(),
+		modTime:    info.ModTime(),
+		file:       file,
+	}
+
+	response.SetHeader("ETag", etag)
+	if static.options.SPA {
+		if path.Ext(name) == ".html" {
+			response.SetHeader("Cache-Control", cacheRevalidate)
+		} else if static.isHashedAsset(name) {
+			response.SetHeader("Cache-Control", cacheImmutable)
+		}
+	}
+
+	return response
+}
+
+// etag returns the ETag for the specified file. Files with a
+// modification time are identified by it along with their size, and
+// files without one, such as those in an embed.FS, by a hash of their
+// content.
+func (static *staticFiles) etag(
+	name string, info fs.FileInfo, content io.ReadSeeker,
+) (string, error) {
+	if !info.ModTime().IsZero() {
+		return fmt.Sprintf(
+			`"%x-%x"`, info.ModTime().UnixNano(), info.Size()), nil
+	}
+
+	if etag, exists := static.etags.Load(name); exists {
+		return etag.(string), nil
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, content); err != nil {
+		return "", err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	etag := fmt.Sprintf(`"%x"`, hash.Sum(nil)[:16])
+	static.etags.Store(name, etag)
+
+	return etag, nil
+}
+
+// listing returns a Response listing the files in the specified
+// directory as HTML.
+func (static *staticFiles) listing(name string) *Response {
+	entries, err := fs.ReadDir(static.fsys, name)
+	if err != nil {
+		return openError(err)
+	}
+
+	var b strings.Builder
+	b.WriteString("<!doctype html>\n")
+	b.WriteString("<meta name=\"viewport\" content=\"width=device-width\">\n")
+	b.WriteString("<pre>\n")
+	if name != "." {
+		b.WriteString("<a href=\"../\">../</a>\n")
+	}
+	for _, entry := range entries {
+		entryName := entry.Name()
+		if entry.IsDir() {
+			entryName += "/"
+		}
+		link := url.URL{Path: entryName}
+		fmt.Fprintf(&b, "<a href=\"%s\">%s</a>\n",
+			html.EscapeString(link.String()), html.EscapeString(entryName))
+	}
+	b.WriteString("</pre>\n")
+
+	return NewResponse(http.StatusOK, b.String()).SetSerializer(
+		NewRawSerializer("data", "text/html; charset=utf-8"))
+}
+
+// serveContent sends the file in the body of the Response using
+// http.ServeContent, which answers range and conditional requests,
+// and returns the HTTP status that was sent.
+func (app *App) serveContent(
+	w http.ResponseWriter, r *http.Request, response *Response,
+) int {
+	content := response.content
+	defer content.file.Close()
+
+	for k, v := range response.Headers {
+		w.Header().Set(k, v)
+	}
+
+	sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+	http.ServeContent(sw, r, content.name, content.modTime, content)
+
+	return sw.status
+}
+
+// statusWriter records the status written to the underlying
+// http.ResponseWriter.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader records the status and writes it.
+func (sw *statusWriter) WriteHeader(status int) {
+	sw.status = status
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+// isHashedAsset reports whether the name of the file contains a hash
+// of its content, using the HashedAssets expression of the options if
+// one is set.
+func (static *staticFiles) isHashedAsset(name string) bool {
+	base := path.Base(name)
+	if static.options.HashedAssets != nil {
+		return static.options.HashedAssets.MatchString(base)
+	}
+
+	stem := strings.TrimSuffix(base, path.Ext(base))
+
+	i := strings.LastIndexAny(stem, ".-")
+	if i < 0 {
+		return false
+	}
+
+	return isHexHash(stem[i+1:])
+}
+
+// isHexHash reports whether s looks like a hash of at least 8
+// lowercase hexadecimal digits. To avoid mistaking numbers and words
+// for a hash, it must contain both a digit and a letter.
+func isHexHash(s string) bool {
+	if len(s) < 8 {
+		return false
+	}
+
+	hasDigit, hasLetter := false, false
+	for _, c := range s {
+		switch {
+		case c >= '0' && c <= '9':
+			hasDigit = true
+		case c >= 'a' && c <= 'f':
+			hasLetter = true
+		default:
+			return false
+		}
+	}
+
+	return hasDigit && hasLetter
+}
+
+// acceptsHTML reports whether the Accept header of the request
+// explicitly accepts HTML, as browsers do when navigating to a page.
+func acceptsHTML(r *http.Request) bool {
+	for _, mr := range parseAccept(r.Header.Get("Accept")) {
+		if mr.mediaType == "text/html" && mr.q > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// notFound returns a 404 Not Found Response.
+func notFound() *Response {
+	return NewResponse(http.StatusNotFound, map[string]interface{}{
+		"error": "not found",
+	})
+}
+
+// openError returns the Response for an error encountered while
+// opening a file.
+func openError(err error) *Response {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return notFound()
+	case errors.Is(err, fs.ErrPermission):
+		return NewResponse(http.StatusForbidden, map[string]interface{}{
+			"error": "forbidden",
+		})
+	}
+
+	if logger != nil {
+		logger.Printf("error : failed to open file: %v\n", err)
+	}
+
+	return NewResponse(http.StatusInternalServerError, map[string]interface{}{
+		"error": "internal server error",
+	})
+}
@@ -0,0 +1,120 @@
+package galago
+
+import (
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+)
+
+// trackingFS counts the files that are open in the wrapped fs.FS.
+type trackingFS struct {
+	fs.FS
+	open int32
+}
+
+// Open opens the named file, counting it until it is closed.
+func (t *trackingFS) Open(name string) (fs.File, error) {
+	file, err := t.FS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	atomic.AddInt32(&t.open, 1)
+	return &trackedFile{File: file, fsys: t}, nil
+}
+
+// trackedFile is a file opened by a trackingFS.
+type trackedFile struct {
+	fs.File
+	fsys *trackingFS
+}
+
+// Close closes the file.
+func (f *trackedFile) Close() error {
+	atomic.AddInt32(&f.fsys.open, -1)
+	return f.File.Close()
+}
+
+func TestStaticFallback(t *testing.T) {
+	fsys := fstest.MapFS{
+		"index.html": &fstest.MapFile{Data: []byte("<html></html>")},
+		"site.css":   &fstest.MapFile{Data: []byte("body{}")},
+	}
+
+	app := &App{}
+	app.AddController(NewController().
+		AddRoute(NewRoute(http.MethodPost, "api/items",
+			func(Request) *Response {
+				return NewResponse(http.StatusCreated, nil)
+			})).
+		AddRoute(NewStaticRoute("", fsys, StaticOptions{SPA: true})))
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		status int
+		allow  string
+	}{
+		{"file", http.MethodGet, "/site.css", http.StatusOK, ""},
+		{"head", http.MethodHead, "/site.css", http.StatusOK, ""},
+		{"spa", http.MethodGet, "/dashboard", http.StatusOK, ""},
+		{"route", http.MethodPost, "/api/items", http.StatusCreated, ""},
+		{"method not allowed", http.MethodGet, "/api/items",
+			http.StatusMethodNotAllowed, "OPTIONS, POST"},
+		{"unknown post", http.MethodPost, "/api/unknown",
+			http.StatusNotFound, ""},
+		{"unknown delete", http.MethodDelete, "/site.css",
+			http.StatusNotFound, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(test.method, test.path, nil)
+			r.Header.Set("Accept", "text/html")
+			w := httptest.NewRecorder()
+			app.ServeHTTP(w, r)
+
+			if w.Code != test.status {
+				t.Errorf("expected status %v, got %v", test.status, w.Code)
+			}
+			if allow := w.Header().Get("Allow"); allow != test.allow {
+				t.Errorf("expected Allow %q, got %q", test.allow, allow)
+			}
+		})
+	}
+}
+
+func TestStaticHashedAssets(t *testing.T) {
+	tests := []struct {
+		name     string
+		options  StaticOptions
+		expected bool
+	}{
+		{"app.3f9a2b1c.js", StaticOptions{}, true},
+		{"index-9c1e0b7d4a.css", StaticOptions{}, true},
+		{"hero-1920x1080.jpg", StaticOptions{}, false},
+		{"report-20240101.pdf", StaticOptions{}, false},
+		{"jquery.min.js", StaticOptions{}, false},
+		{"app.deadbeef.js", StaticOptions{}, false},
+		{"index-B7x2kQ9d.js", StaticOptions{}, false},
+		{"index-B7x2kQ9d.js", StaticOptions{
+			HashedAssets: regexp.MustCompile(`-[A-Za-z0-9_-]{8}\.js$`),
+		}, true},
+		{"app.3f9a2b1c.js", StaticOptions{
+			HashedAssets: regexp.MustCompile(`-[A-Za-z0-9_-]{8}\.js$`),
+		}, false},
+	}
+
+	for _, test := range tests {
+		static := &staticFiles{options: test.options}
+		if hashed := static.isHashedAsset(test.name); hashed != test.expected {
+			t.Errorf("%v: expected %v, got %v",
+				test.name, test.expected, hashed)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package galago
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// StreamFunc writes the body of a streaming Response to the Stream.
+// If it returns an error before anything has been written, the client
+// receives a 500 Internal Server Error. Otherwise, the connection is
+// closed so that the client does not mistake the body for a complete
+// response.
+type StreamFunc func(stream *Stream) error
+
+// Stream is used to write the body of a streaming Response directly to
+// the client.
+type Stream struct {
+	w   io.Writer
+	ctx context.Context
+}
+
+// NewStreamResponse creates a new Response using the specified HTTP
+// Status code that streams its body to the client by calling fn once
+// the headers of the Response have been sent. The Serializer for the
+// Response is not used, so the Content-Type header should be set on
+// the Response. It defaults to application/octet-stream.
+//
+// Middleware After functions run before fn is called, and Terminate
+// functions run once fn has returned.
+func NewStreamResponse(status int, fn StreamFunc) *Response {
+	response := NewResponse(status, nil)
+	response.stream = fn
+
+	return response
+}
+
+// Write writes the data to the client. The status and headers of the
+// Response are sent before the first write.
+func (stream *Stream) Write(data []byte) (int, error) {
+	if err := stream.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return stream.w.Write(data)
+}
+
+// Flush sends any buffered data to the client. If nothing has been
+// written yet, the status and headers of the Response are sent.
+func (stream *Stream) Flush() {
+	if flusher, isFlusher := stream.w.(http.Flusher); isFlusher {
+		flusher.Flush()
+	}
+}
+
+// Context returns the context of the request, which is done once the
+// client disconnects.
+func (stream *Stream) Context() context.Context {
+	return stream.ctx
+}
+
+// streamBody returns a function that writes the body of a streaming
+// Response. A panic within the StreamFunc is recovered and returned as
+// an error.
+func (app *App) streamBody(r *http.Request, fn StreamFunc) func(
+	io.Writer) error {
+	return func(w io.Writer) (err error) {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				if recovered == http.ErrAbortHandler {
+					panic(recovered)
+				}
+				app.recoverPanic(r, recovered)
+				err = errors.New("internal server error")
+			}
+			if err != nil {
+				err = fmt.Errorf("failed to stream response: %w", err)
+			}
+		}()
+
+		return fn(&Stream{w: w, ctx: r.Context()})
+	}
+}
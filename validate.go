@@ -0,0 +1,407 @@
+package galago
+
+import (
+	"fmt"
+	"net/http"
+	"net/mail"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Validator checks a value against a validation rule. The param is
+// the text following the equals sign in the rule, such as "64" for the
+// rule `max=64`, and is empty if the rule has none. Pointers are
+// dereferenced before the value is passed to the Validator. If the
+// value is invalid, an error describing the problem is returned.
+type Validator func(value interface{}, param string) error
+
+// builtinValidators are the validation rules that can be used in any
+// App. Validators registered on an App with the same name take
+// precedence.
+var builtinValidators = map[string]Validator{
+	"min":   validateMin,
+	"max":   validateMax,
+	"email": validateEmail,
+	"oneof": validateOneOf,
+}
+
+// ValidationError is returned by request.Bind(dst) and
+// request.Validate(v) when one or more fields of a struct do not
+// satisfy the rules in their `validate` tags.
+type ValidationError struct {
+	// The errors for each field that failed validation.
+	Errors []FieldError
+}
+
+// Error returns a description of every FieldError in the
+// ValidationError.
+func (err *ValidationError) Error() string {
+	messages := make([]string, len(err.Errors))
+	for i, fieldErr := range err.Errors {
+		messages[i] = fieldErr.Error()
+	}
+
+	return "validation failed: " + strings.Join(messages, "; ")
+}
+
+// Response creates a 422 Unprocessable Entity Response describing each
+// FieldError in the ValidationError.
+func (err *ValidationError) Response() *Response {
+	return NewResponse(
+		http.StatusUnprocessableEntity,
+		map[string]interface{}{
+			"error":  "validation failed",
+			"fields": fieldErrorData(err.Errors),
+		},
+	)
+}
+
+// RegisterValidator registers a Validator with the App that can be
+// used in `validate` tags under the specified name. Validators should
+// be registered before the App starts listening.
+func (app *App) RegisterValidator(name string, validator Validator) {
+	if app.validators == nil {
+		app.validators = map[string]Validator{}
+	}
+	app.validators[name] = validator
+}
+
+// validator retrieves the Validator registered under the specified
+// name, falling back to the built in Validators.
+func (app *App) validator(name string) Validator {
+	if app != nil {
+		if validator, exists := app.validators[name]; exists {
+			return validator
+		}
+	}
+
+	return builtinValidators[name]
+}
+
+// Validate checks each field of the struct pointed to by v against
+// the comma separated rules in its `validate` tag, along with any
+// Validators registered on the App. For example
+//
+//	Name string `json:"name" validate:"required,min=1,max=64"`
+//
+// The built in rules are
+//
+//   - `required` fails if the field holds the zero value.
+//   - `omitempty` skips the other rules if the field holds the zero
+//     value.
+//   - `min=n` and `max=n` limit the value of a number, or the length
+//     of a string, slice or map.
+//   - `email` requires a string holding a valid email address.
+//   - `oneof=a b c` requires the value to be one of the space
+//     separated options.
+//
+// Rules are checked against zero values too, so `min=1` rejects a
+// field holding 0 unless `omitempty` is also used. Nil pointers are
+// only checked by `required`, so use a pointer for a field whose
+// rules only apply when it is present in the request. Nested structs,
+// along with the structs in slices and maps, are validated as well.
+//
+// If any field fails validation, a *ValidationError describing each
+// failure is returned. Its Response() function can be used to respond
+// with a 422 Unprocessable Entity. If a tag uses a rule that does not
+// exist, an error is returned instead.
+func (request *Request) Validate(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return fmt.Errorf("cannot validate nil %T", v)
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return fmt.Errorf("cannot validate %T, expected a struct", v)
+	}
+
+	vd := validation{app: request.app}
+	if err := vd.validateStruct(rv, "", true); err != nil {
+		return err
+	}
+	if len(vd.errs) > 0 {
+		return &ValidationError{Errors: vd.errs}
+	}
+
+	return nil
+}
+
+// validation checks a struct against the rules in its `validate` tags,
+// collecting an error for every field that fails.
+type validation struct {
+	app  *App
+	errs []FieldError
+}
+
+// validateStruct validates each field of the struct v. The names of
+// the fields are taken from the tags used by request.Bind(dst), and
+// only the `json` tag is considered unless top is true.
+func (vd *validation) validateStruct(
+	v reflect.Value, prefix string, top bool,
+) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		fv := v.Field(i)
+
+		if sf.Anonymous && !hasBindTag(sf) {
+			ft := sf.Type
+			if ft.Kind() == reflect.Ptr {
+				if sf.PkgPath != "" || fv.IsNil() {
+					continue
+				}
+				ft = ft.Elem()
+				fv = fv.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				if err := vd.validateStruct(fv, prefix, top); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+
+		if sf.PkgPath != "" {
+			continue
+		}
+
+		name, source := bindName(sf, top)
+		if err := vd.validateField(
+			fv, sf.Tag.Get("validate"), prefix+name, source,
+		); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateField checks the value v against the specified rules and
+// then validates any structs it holds.
+func (vd *validation) validateField(
+	v reflect.Value, tag string, field, source string,
+) error {
+	if tag == "-" {
+		return nil
+	}
+
+	rules := []string{}
+	required, omitEmpty := false, false
+	for _, rule := range strings.Split(tag, ",") {
+		rule = strings.TrimSpace(rule)
+		switch rule {
+		case "":
+		case "required":
+			required = true
+		case "omitempty":
+			omitEmpty = true
+		default:
+			rules = append(rules, rule)
+		}
+	}
+
+	if v.IsZero() {
+		if required {
+			vd.errs = append(vd.errs, FieldError{
+				Field: field, Source: source,
+				Rule: "required", Message: "is required",
+			})
+			return nil
+		}
+		if omitEmpty {
+			return nil
+		}
+	}
+
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+
+	for _, rule := range rules {
+		name, param := rule, ""
+		if i := strings.Index(rule, "="); i >= 0 {
+			name, param = rule[:i], rule[i+1:]
+		}
+
+		validator := vd.app.validator(name)
+		if validator == nil {
+			return fmt.Errorf(
+				"unknown validation rule %q for field %v", name, field)
+		}
+
+		if err := validator(v.Interface(), param); err != nil {
+			vd.errs = append(vd.errs, FieldError{
+				Field: field, Source: source,
+				Rule: name, Message: err.Error(),
+			})
+		}
+	}
+
+	return vd.validateNested(v, field, source)
+}
+
+// validateNested validates the structs held by v, which is either a
+// struct or a slice, array or map of them.
+func (vd *validation) validateNested(
+	v reflect.Value, field, source string,
+) error {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		if isTextType(v.Type()) {
+			return nil
+		}
+		return vd.validateStruct(v, field+".", false)
+
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			err := vd.validateNested(
+				v.Index(i), field+"."+strconv.Itoa(i), source)
+			if err != nil {
+				return err
+			}
+		}
+
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			err := vd.validateNested(
+				iter.Value(), fmt.Sprintf("%v.%v", field, iter.Key()),
+				source)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// hasBindTag reports whether the field has any of the tags used by
+// request.Bind(dst).
+func hasBindTag(sf reflect.StructField) bool {
+	for _, key := range []string{"json", "query", "path", "header"} {
+		if _, exists := sf.Tag.Lookup(key); exists {
+			return true
+		}
+	}
+
+	return false
+}
+
+// bindName returns the name under which the field is bound by
+// request.Bind(dst) and the source it is bound from. When a field is
+// bound from several sources, the one that takes precedence is used.
+func bindName(sf reflect.StructField, top bool) (string, string) {
+	if top {
+		for _, source := range []string{"header", "path", "query"} {
+			name, exists := sf.Tag.Lookup(source)
+			if exists && name != "-" {
+				return name, source
+			}
+		}
+	}
+
+	name := strings.Split(sf.Tag.Get("json"), ",")[0]
+	if name == "" || name == "-" {
+		name = sf.Name
+	}
+
+	return name, "body"
+}
+
+// validationSize returns the number used to compare the value against
+// the min and max rules, along with the format of the message used
+// when the comparison fails.
+func validationSize(value interface{}) (float64, string, bool) {
+	v := reflect.ValueOf(value)
+	if f, isNumber := toFloat(v); isNumber {
+		return f, "must be %v %v", true
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return float64(utf8.RuneCountInString(v.String())),
+			"must be %v %v characters long", true
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return float64(v.Len()), "must contain %v %v items", true
+	}
+
+	return 0, "", false
+}
+
+// validateMin implements the `min=n` rule.
+func validateMin(value interface{}, param string) error {
+	limit, err := strconv.ParseFloat(param, 64)
+	if err != nil {
+		return fmt.Errorf("invalid min %q", param)
+	}
+
+	size, format, ok := validationSize(value)
+	if !ok {
+		return fmt.Errorf("min can not be applied to %T", value)
+	}
+	if size < limit {
+		return fmt.Errorf(format, "at least", param)
+	}
+
+	return nil
+}
+
+// validateMax implements the `max=n` rule.
+func validateMax(value interface{}, param string) error {
+	limit, err := strconv.ParseFloat(param, 64)
+	if err != nil {
+		return fmt.Errorf("invalid max %q", param)
+	}
+
+	size, format, ok := validationSize(value)
+	if !ok {
+		return fmt.Errorf("max can not be applied to %T", value)
+	}
+	if size > limit {
+		return fmt.Errorf(format, "at most", param)
+	}
+
+	return nil
+}
+
+// validateEmail implements the `email` rule.
+func validateEmail(value interface{}, param string) error {
+	v := reflect.ValueOf(value)
+	if v.Kind() == reflect.String {
+		s := v.String()
+		addr, err := mail.ParseAddress(s)
+		if err == nil && addr.Name == "" && addr.Address == s {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("must be a valid email address")
+}
+
+// validateOneOf implements the `oneof=a b c` rule.
+func validateOneOf(value interface{}, param string) error {
+	options := strings.Fields(param)
+	s := fmt.Sprint(value)
+	for _, option := range options {
+		if s == option {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("must be one of %v", strings.Join(options, ", "))
+}
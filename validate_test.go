@@ -0,0 +1,58 @@
+package galago
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidateZeroValues(t *testing.T) {
+	type query struct {
+		Page    int     `json:"page" validate:"min=1"`
+		Size    int     `json:"size" validate:"omitempty,min=10"`
+		Email   string  `json:"email" validate:"email"`
+		Contact string  `json:"contact" validate:"omitempty,email"`
+		Limit   *int    `json:"limit" validate:"min=1"`
+		Sort    *string `json:"sort" validate:"required"`
+	}
+
+	err := (&Request{}).Validate(&query{})
+
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("expected a ValidationError, got %v", err)
+	}
+
+	failed := map[string]string{}
+	for _, fieldErr := range validationErr.Errors {
+		failed[fieldErr.Field] = fieldErr.Rule
+	}
+
+	expected := map[string]string{
+		"page":  "min",
+		"email": "email",
+		"sort":  "required",
+	}
+	if len(failed) != len(expected) {
+		t.Errorf("expected failures %v, got %v", expected, failed)
+	}
+	for field, rule := range expected {
+		if failed[field] != rule {
+			t.Errorf("expected %v to fail %v, got %q",
+				field, rule, failed[field])
+		}
+	}
+}
+
+func TestValidatePointers(t *testing.T) {
+	type query struct {
+		Limit *int `json:"limit" validate:"min=1"`
+	}
+
+	zero, one := 0, 1
+	if err := (&Request{}).Validate(&query{Limit: &one}); err != nil {
+		t.Errorf("expected a valid limit, got %v", err)
+	}
+	if err := (&Request{}).Validate(&query{Limit: &zero}); err == nil {
+		t.Error("expected a limit of 0 to fail validation")
+	}
+}
@@ -0,0 +1,739 @@
+package galago
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+// DefaultWebSocketPingInterval is the interval at which pings are sent
+// on a WebSocket when no interval has been configured on the App.
+const DefaultWebSocketPingInterval = 30 * time.Second
+
+// DefaultWebSocketMaxMessageBytes is the maximum size of a message
+// received on a WebSocket in bytes when no maximum has been configured
+// on the App.
+const DefaultWebSocketMaxMessageBytes = 1 << 20
+
+// MessageType is the type of a message sent over a WebSocket.
+type MessageType int
+
+// Types of messages that can be sent over a WebSocket.
+const (
+	TextMessage   MessageType = 1
+	BinaryMessage MessageType = 2
+)
+
+// Close codes sent when a WebSocket is closed, as defined in RFC 6455.
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseUnsupportedData = 1003
+	CloseNoStatus        = 1005
+	CloseAbnormal        = 1006
+	CloseInvalidPayload  = 1007
+	ClosePolicyViolation = 1008
+	CloseMessageTooBig   = 1009
+	CloseInternalError   = 1011
+)
+
+// ErrWebSocketClosed is returned when writing to a WebSocket that has
+// been closed.
+var ErrWebSocketClosed = errors.New("websocket is closed")
+
+// Opcodes of the frames sent over a WebSocket.
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+// webSocketGUID is appended to the key sent by the client to compute
+// the key accepting the opening handshake.
+const webSocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// The amount of time to wait for a frame to be written, and for the
+// client to answer the closing handshake.
+const (
+	webSocketWriteTimeout = 10 * time.Second
+	webSocketCloseTimeout = 5 * time.Second
+)
+
+// CloseError is returned from reading a WebSocket once it has been
+// closed, along with the close code and reason sent by the client. If
+// the connection was lost without a closing handshake, the code is
+// CloseAbnormal.
+type CloseError struct {
+	// The close code.
+	Code int
+	// The reason the WebSocket was closed.
+	Reason string
+}
+
+// Error returns a description of the CloseError.
+func (err *CloseError) Error() string {
+	if err.Reason == "" {
+		return fmt.Sprintf("websocket closed with code %d", err.Code)
+	}
+
+	return fmt.Sprintf(
+		"websocket closed with code %d: %s", err.Code, err.Reason)
+}
+
+// WebSocketHandler handles a WebSocket opened with a Route created by
+// NewWebSocketRoute. The WebSocket is closed once the handler returns.
+type WebSocketHandler func(request Request, conn *WebSocket) error
+
+// WebSocket is a connection upgraded to the WebSocket protocol. It is
+// safe to write to a WebSocket concurrently with reading from it.
+//
+// Pings are sent at the WebSocketPingInterval of the App to keep the
+// connection alive, and pings sent by the client are answered
+// automatically.
+type WebSocket struct {
+	conn            net.Conn
+	br              *bufio.Reader
+	bw              *bufio.Writer
+	ctx             context.Context
+	cancel          context.CancelFunc
+	maxMessageBytes int64
+	messages        chan webSocketMessage
+	readDone        chan struct{}
+	readErr         error
+	handlerDone     chan struct{}
+	finished        chan struct{}
+	lastSeen        int64
+	writeMu         sync.Mutex
+	closeSent       bool
+}
+
+// webSocketMessage is a complete message read from a WebSocket.
+type webSocketMessage struct {
+	messageType MessageType
+	data        []byte
+}
+
+// NewWebSocketRoute creates a new Route for the specified path that
+// upgrades GET requests to the WebSocket protocol and passes the
+// connection to the handler.
+//
+// Rate limits and Middleware apply to the Route in the same way they
+// apply to any other Route, so a Before or Wrap function can be used
+// to authenticate the request before the connection is upgraded. A
+// request that is not a valid WebSocket handshake receives a 400 Bad
+// Request or a 426 Upgrade Required response, and a request from
+// another origin receives a 403 Forbidden response unless it is
+// allowed by the CheckWebSocketOrigin function of the App.
+//
+// Terminate Middleware runs once the WebSocket has been closed.
+func NewWebSocketRoute(path string, handler WebSocketHandler) *Route {
+	return NewRoute(http.MethodGet, path, func(request Request) *Response {
+		return request.upgrade(handler)
+	})
+}
+
+// upgrade validates the opening handshake of the WebSocket protocol
+// sent with the Request, returning a Response that upgrades the
+// connection and passes it to the handler.
+func (request *Request) upgrade(handler WebSocketHandler) *Response {
+	r := request.HTTPRequest
+	if r.Method != http.MethodGet ||
+		!headerHasToken(r.Header, "Connection", "upgrade") ||
+		!headerHasToken(r.Header, "Upgrade", "websocket") {
+		return NewResponse(http.StatusUpgradeRequired, map[string]interface{}{
+			"error": "websocket upgrade required",
+		}).SetHeader("Upgrade", "websocket").SetHeader("Connection", "Upgrade")
+	}
+
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		return NewResponse(http.StatusUpgradeRequired, map[string]interface{}{
+			"error": "unsupported websocket version",
+		}).SetHeader("Sec-WebSocket-Version", "13")
+	}
+
+	key, err := base64.StdEncoding.DecodeString(
+		r.Header.Get("Sec-WebSocket-Key"))
+	if err != nil || len(key) != 16 {
+		return NewResponse(http.StatusBadRequest, map[string]interface{}{
+			"error": "invalid websocket key",
+		})
+	}
+
+	checkOrigin := sameOrigin
+	if request.app != nil && request.app.CheckWebSocketOrigin != nil {
+		checkOrigin = request.app.CheckWebSocketOrigin
+	}
+	if !checkOrigin(r) {
+		return NewResponse(http.StatusForbidden, map[string]interface{}{
+			"error": "origin not allowed",
+		})
+	}
+
+	response := NewResponse(http.StatusSwitchingProtocols, nil)
+	response.upgrade = func(conn *WebSocket) error {
+		return handler(*request, conn)
+	}
+
+	return response
+}
+
+// serveWebSocket completes the opening handshake by hijacking the
+// connection, and then passes the WebSocket to the upgrade function of
+// the Response, returning the HTTP status that was sent. The
+// WebSocket is closed once the function returns.
+func (app *App) serveWebSocket(
+	w http.ResponseWriter, r *http.Request, response *Response,
+) int {
+	hijacker, isHijacker := w.(http.Hijacker)
+	if !isHijacker {
+		app.writeError(w, http.StatusInternalServerError,
+			"websocket upgrade not supported")
+		return http.StatusInternalServerError
+	}
+
+	header := w.Header()
+	for k, v := range response.Headers {
+		header.Set(k, v)
+	}
+	header.Set("Upgrade", "websocket")
+	header.Set("Connection", "Upgrade")
+	header.Set("Sec-WebSocket-Accept",
+		webSocketAccept(r.Header.Get("Sec-WebSocket-Key")))
+
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		app.writeError(w, http.StatusInternalServerError,
+			"websocket upgrade not supported")
+		return http.StatusInternalServerError
+	}
+
+	// The deadlines set by the server for the request no longer apply
+	// once the connection has been hijacked.
+	conn.SetDeadline(time.Time{})
+	conn.SetWriteDeadline(time.Now().Add(webSocketWriteTimeout))
+
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	header.Write(rw)
+	rw.WriteString("\r\n")
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return http.StatusSwitchingProtocols
+	}
+
+	maxMessageBytes := app.WebSocketMaxMessageBytes
+	if maxMessageBytes <= 0 {
+		maxMessageBytes = DefaultWebSocketMaxMessageBytes
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	ws := &WebSocket{
+		conn:            conn,
+		br:              rw.Reader,
+		bw:              rw.Writer,
+		ctx:             ctx,
+		cancel:          cancel,
+		maxMessageBytes: maxMessageBytes,
+		messages:        make(chan webSocketMessage),
+		readDone:        make(chan struct{}),
+		handlerDone:     make(chan struct{}),
+		finished:        make(chan struct{}),
+		lastSeen:        time.Now().UnixNano(),
+	}
+
+	app.addWebSocket(ws)
+	defer app.removeWebSocket(ws)
+
+	app.runWebSocket(r, ws, response.upgrade)
+
+	return http.StatusSwitchingProtocols
+}
+
+// runWebSocket passes the WebSocket to fn while reading messages and
+// sending pings in the background. Once fn returns, the WebSocket is
+// closed. A panic within fn is recovered and closes the WebSocket with
+// CloseInternalError.
+func (app *App) runWebSocket(
+	r *http.Request, ws *WebSocket, fn func(*WebSocket) error,
+) {
+	defer close(ws.finished)
+
+	interval := DefaultWebSocketPingInterval
+	if app.WebSocketPingInterval != 0 {
+		interval = app.WebSocketPingInterval
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ws.readLoop()
+	}()
+	if interval > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ws.keepalive(interval)
+		}()
+	}
+
+	err := func() (err error) {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				if recovered == http.ErrAbortHandler {
+					panic(recovered)
+				}
+				app.recoverPanic(r, recovered)
+				err = errors.New("internal server error")
+			}
+		}()
+
+		return fn(ws)
+	}()
+	close(ws.handlerDone)
+
+	// An error caused by the client closing the WebSocket, or by the
+	// connection being lost, is the normal end of a WebSocket.
+	code := CloseNormal
+	var closeErr *CloseError
+	if err != nil && ws.ctx.Err() == nil && !errors.As(err, &closeErr) {
+		code = CloseInternalError
+		if logger != nil {
+			logger.Printf("error : websocket handler failed: %v\n", err)
+		}
+	}
+
+	// Wait for the client to answer the closing handshake before
+	// closing the connection.
+	if ws.sendClose(code, "") == nil {
+		select {
+		case <-ws.readDone:
+		case <-time.After(webSocketCloseTimeout):
+		}
+	}
+
+	ws.cancel()
+	ws.conn.Close()
+	wg.Wait()
+}
+
+// Context returns a context that is done once the WebSocket has been
+// closed by the client, or the connection has been lost.
+func (ws *WebSocket) Context() context.Context {
+	return ws.ctx
+}
+
+// ReadMessage reads the next message sent by the client, blocking
+// until one is received. Once the WebSocket has been closed, a
+// *CloseError is returned.
+//
+// The WebSocket stops reading from the connection until each message
+// has been read, so a client whose messages are not read stops
+// answering pings and is disconnected.
+func (ws *WebSocket) ReadMessage() (MessageType, []byte, error) {
+	select {
+	case msg := <-ws.messages:
+		return msg.messageType, msg.data, nil
+	case <-ws.readDone:
+		return 0, nil, ws.readErr
+	}
+}
+
+// WriteMessage sends a message of the specified type to the client.
+func (ws *WebSocket) WriteMessage(messageType MessageType, data []byte) error {
+	if messageType != TextMessage && messageType != BinaryMessage {
+		return fmt.Errorf("invalid websocket message type %d", messageType)
+	}
+
+	return ws.writeFrame(byte(messageType), data)
+}
+
+// WriteText sends a text message to the client.
+func (ws *WebSocket) WriteText(text string) error {
+	return ws.WriteMessage(TextMessage, []byte(text))
+}
+
+// Close starts the closing handshake by sending the specified close
+// code and reason to the client. Any messages that are still sent by
+// the client can be read until it answers, at which point reading
+// returns a *CloseError. The connection is closed once the handler
+// returns.
+func (ws *WebSocket) Close(code int, reason string) error {
+	if !validCloseCode(code) {
+		return fmt.Errorf("invalid websocket close code %d", code)
+	}
+	if len(reason) > 123 {
+		return errors.New("websocket close reason is too long")
+	}
+
+	return ws.sendClose(code, reason)
+}
+
+// readLoop reads the messages sent by the client until the WebSocket
+// is closed, handing each of them to ReadMessage. Once the handler
+// has returned, messages are discarded.
+func (ws *WebSocket) readLoop() {
+	defer close(ws.readDone)
+	defer ws.cancel()
+
+	for {
+		messageType, data, err := ws.readMessage()
+		if err != nil {
+			var closeErr *CloseError
+			if !errors.As(err, &closeErr) {
+				err = &CloseError{Code: CloseAbnormal}
+			}
+			ws.readErr = err
+			return
+		}
+
+		select {
+		case ws.messages <- webSocketMessage{messageType, data}:
+		case <-ws.handlerDone:
+		}
+	}
+}
+
+// readMessage reads the frames of the next message sent by the
+// client, answering any control frames that are received in between.
+func (ws *WebSocket) readMessage() (MessageType, []byte, error) {
+	var messageType MessageType
+	var data []byte
+
+	for {
+		fin, opcode, payload, err := ws.readFrame(
+			ws.maxMessageBytes - int64(len(data)))
+		if err != nil {
+			return 0, nil, err
+		}
+		atomic.StoreInt64(&ws.lastSeen, time.Now().UnixNano())
+
+		switch opcode {
+		case opPing:
+			ws.writeFrame(opPong, payload)
+			continue
+		case opPong:
+			continue
+		case opClose:
+			return 0, nil, ws.receiveClose(payload)
+		case opContinuation:
+			if messageType == 0 {
+				return 0, nil, ws.fail(
+					CloseProtocolError, "unexpected continuation frame")
+			}
+		case opText, opBinary:
+			if messageType != 0 {
+				return 0, nil, ws.fail(
+					CloseProtocolError, "expected continuation frame")
+			}
+			messageType = MessageType(opcode)
+		default:
+			return 0, nil, ws.fail(CloseProtocolError, "unknown opcode")
+		}
+
+		data = append(data, payload...)
+		if !fin {
+			continue
+		}
+
+		if messageType == TextMessage && !utf8.Valid(data) {
+			return 0, nil, ws.fail(
+				CloseInvalidPayload, "invalid UTF-8 in text message")
+		}
+
+		return messageType, data, nil
+	}
+}
+
+// readFrame reads a single frame sent by the client. The payload of a
+// data frame can be at most limit bytes.
+func (ws *WebSocket) readFrame(limit int64) (bool, byte, []byte, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(ws.br, head[:]); err != nil {
+		return false, 0, nil, err
+	}
+
+	fin := head[0]&0x80 != 0
+	opcode := head[0] & 0x0F
+	if head[0]&0x70 != 0 {
+		return false, 0, nil, ws.fail(
+			CloseProtocolError, "reserved bits are set")
+	}
+	if head[1]&0x80 == 0 {
+		return false, 0, nil, ws.fail(
+			CloseProtocolError, "frame is not masked")
+	}
+
+	length := int64(head[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(ws.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(ws.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		if ext[0]&0x80 != 0 {
+			return false, 0, nil, ws.fail(
+				CloseProtocolError, "invalid frame length")
+		}
+		length = int64(binary.BigEndian.Uint64(ext[:]))
+	}
+
+	if opcode >= opClose {
+		if !fin || length > 125 {
+			return false, 0, nil, ws.fail(
+				CloseProtocolError, "invalid control frame")
+		}
+	} else if length > limit {
+		return false, 0, nil, ws.fail(
+			CloseMessageTooBig, "message is too big")
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(ws.br, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(ws.br, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+
+	return fin, opcode, payload, nil
+}
+
+// receiveClose answers a close frame sent by the client, returning the
+// CloseError describing it.
+func (ws *WebSocket) receiveClose(payload []byte) error {
+	if len(payload) == 0 {
+		ws.sendClose(CloseNormal, "")
+		return &CloseError{Code: CloseNoStatus}
+	}
+
+	if len(payload) < 2 {
+		return ws.fail(CloseProtocolError, "invalid close frame")
+	}
+
+	code := int(binary.BigEndian.Uint16(payload))
+	if !validCloseCode(code) {
+		return ws.fail(CloseProtocolError, "invalid close code")
+	}
+
+	reason := payload[2:]
+	if !utf8.Valid(reason) {
+		return ws.fail(CloseInvalidPayload, "invalid UTF-8 in close reason")
+	}
+
+	ws.sendClose(code, "")
+
+	return &CloseError{Code: code, Reason: string(reason)}
+}
+
+// fail closes the WebSocket with the specified code and reason after
+// the client violated the protocol, returning the CloseError
+// describing it.
+func (ws *WebSocket) fail(code int, reason string) error {
+	ws.sendClose(code, reason)
+
+	return &CloseError{Code: code, Reason: reason}
+}
+
+// sendClose sends a close frame to the client, unless one has already
+// been sent.
+func (ws *WebSocket) sendClose(code int, reason string) error {
+	ws.writeMu.Lock()
+	defer ws.writeMu.Unlock()
+
+	if ws.closeSent {
+		return ErrWebSocketClosed
+	}
+	ws.closeSent = true
+
+	payload := make([]byte, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	copy(payload[2:], reason)
+
+	return ws.writeFrameLocked(opClose, payload)
+}
+
+// writeFrame writes a single frame to the client, unless the WebSocket
+// has been closed.
+func (ws *WebSocket) writeFrame(opcode byte, payload []byte) error {
+	ws.writeMu.Lock()
+	defer ws.writeMu.Unlock()
+
+	if ws.closeSent || ws.ctx.Err() != nil {
+		return ErrWebSocketClosed
+	}
+
+	return ws.writeFrameLocked(opcode, payload)
+}
+
+// writeFrameLocked writes a single frame to the client. The caller
+// must hold the write lock.
+func (ws *WebSocket) writeFrameLocked(opcode byte, payload []byte) error {
+	head := make([]byte, 2, 10)
+	head[0] = 0x80 | opcode
+
+	length := len(payload)
+	switch {
+	case length < 126:
+		head[1] = byte(length)
+	case length <= 0xFFFF:
+		head[1] = 126
+		head = binary.BigEndian.AppendUint16(head, uint16(length))
+	default:
+		head[1] = 127
+		head = binary.BigEndian.AppendUint64(head, uint64(length))
+	}
+
+	ws.conn.SetWriteDeadline(time.Now().Add(webSocketWriteTimeout))
+	ws.bw.Write(head)
+	ws.bw.Write(payload)
+
+	return ws.bw.Flush()
+}
+
+// keepalive sends a ping at the specified interval until the WebSocket
+// is closed. If nothing has been received from the client since the
+// previous ping, the connection is considered lost and is closed.
+func (ws *WebSocket) keepalive(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var lastPing int64
+	for {
+		select {
+		case <-ws.ctx.Done():
+			return
+		case <-ticker.C:
+			if lastPing != 0 && atomic.LoadInt64(&ws.lastSeen) < lastPing {
+				ws.conn.Close()
+				return
+			}
+
+			lastPing = time.Now().UnixNano()
+			if err := ws.writeFrame(opPing, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// addWebSocket tracks the WebSocket so that it can be closed when the
+// App is shut down.
+func (app *App) addWebSocket(ws *WebSocket) {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+
+	if app.webSockets == nil {
+		app.webSockets = map[*WebSocket]struct{}{}
+	}
+	app.webSockets[ws] = struct{}{}
+}
+
+// removeWebSocket stops tracking the WebSocket once it has been
+// closed.
+func (app *App) removeWebSocket(ws *WebSocket) {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+
+	delete(app.webSockets, ws)
+}
+
+// closeWebSockets closes every open WebSocket with CloseGoingAway and
+// waits until their handlers have returned, or ctx is done.
+func (app *App) closeWebSockets(ctx context.Context) {
+	app.mu.Lock()
+	sockets := make([]*WebSocket, 0, len(app.webSockets))
+	for ws := range app.webSockets {
+		sockets = append(sockets, ws)
+	}
+	app.mu.Unlock()
+
+	for _, ws := range sockets {
+		ws.sendClose(CloseGoingAway, "server is shutting down")
+	}
+
+	for _, ws := range sockets {
+		select {
+		case <-ws.finished:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// webSocketAccept returns the key accepting the opening handshake for
+// the key sent by the client.
+func webSocketAccept(key string) string {
+	hash := sha1.Sum([]byte(key + webSocketGUID))
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// validCloseCode reports whether the close code can be sent in a close
+// frame.
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1014:
+		return true
+	case code >= 3000 && code <= 4999:
+		return true
+	}
+
+	return false
+}
+
+// headerHasToken reports whether the comma separated list of tokens in
+// the specified header contains the token, ignoring case.
+func headerHasToken(h http.Header, key, token string) bool {
+	for _, value := range h.Values(key) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// sameOrigin reports whether the request has no Origin header, or an
+// Origin matching its Host.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+
+	return strings.EqualFold(u.Host, r.Host)
+}
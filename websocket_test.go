@@ -0,0 +1,47 @@
+package galago
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// dialWebSocket sends a WebSocket handshake for the path to the server
+// with the specified extra headers and returns the response.
+func dialWebSocket(
+	t *testing.T, server *httptest.Server, path string, headers http.Header,
+) *http.Response {
+	t.Helper()
+
+	addr := server.Listener.Addr().String()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	r, err := http.NewRequest(http.MethodGet, "http://"+addr+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Connection", "Upgrade")
+	r.Header.Set("Upgrade", "websocket")
+	r.Header.Set("Sec-WebSocket-Version", "13")
+	r.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	for k, v := range headers {
+		r.Header[k] = v
+	}
+	if err := r.Write(conn); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := http.ReadResponse(bufio.NewReader(conn), r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}